@@ -0,0 +1,317 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/classifier"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+)
+
+const (
+	// categoricalMaxValues is the most distinct values a categorical column
+	// can have in the sample
+	categoricalMaxValues = 100
+	// categoricalMaxRatio is the highest ratio of distinct to total values a
+	// categorical column can have in the sample
+	categoricalMaxRatio = 0.5
+)
+
+var (
+	// localTypePrecedence breaks ties between equally probable types, with the
+	// most specific types first. Coordinates come after the plain numbers
+	// since the column name is the only thing telling them apart.
+	localTypePrecedence = []string{
+		model.BoolType,
+		model.IntegerType,
+		model.RealType,
+		model.LatitudeType,
+		model.LongitudeType,
+		model.DateTimeType,
+		model.EmailType,
+		model.URIType,
+		model.CategoricalType,
+		model.StringType,
+	}
+
+	booleanValues = map[string]bool{
+		"true": true, "false": true, "t": true, "f": true,
+		"yes": true, "no": true, "y": true, "n": true,
+		"0": true, "1": true,
+	}
+
+	dateTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006/01/02",
+		"01/02/2006",
+		"1/2/2006",
+		"01/02/2006 15:04:05",
+		"01-02-2006",
+		"02-Jan-2006",
+		"2 Jan 2006",
+		"Jan 2, 2006",
+		"January 2, 2006",
+		time.RFC1123,
+		time.RFC1123Z,
+	}
+
+	latitudeTokens  = map[string]bool{"lat": true, "latitude": true}
+	longitudeTokens = map[string]bool{"lon": true, "lng": true, "longitude": true}
+
+	emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// classifyLocal infers the type of every selected column from a sample of the
+// dataset and writes the result in the classification format produced by the
+// Simon pipeline. Columns that are not selected have no suggested types.
+func classifyLocal(datasetPath string, outputPath string, sampleSize int, filter *variables.Filter) error {
+	header, columns, err := readColumnSample(datasetPath, sampleSize)
+	if err != nil {
+		return err
+	}
+	err = filter.Validate(header)
+	if err != nil {
+		return err
+	}
+
+	classification := &model.ClassificationData{
+		Path:          datasetPath,
+		Labels:        make([][]string, len(header)),
+		Probabilities: make([][]float64, len(header)),
+	}
+	for i, name := range header {
+		if !filter.Selected(name) {
+			classification.Labels[i], classification.Probabilities[i] = []string{}, []float64{}
+			continue
+		}
+		classification.Labels[i], classification.Probabilities[i] = classifyColumn(name, columns[i])
+	}
+
+	bytes, err := json.MarshalIndent(classification, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize classification result")
+	}
+	err = os.MkdirAll(path.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create classification output directory")
+	}
+	err = ioutil.WriteFile(outputPath, bytes, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store classification result")
+	}
+
+	return nil
+}
+
+// readColumnSample reads the header and the non empty values of the first
+// sampleSize rows of each column.
+func readColumnSample(datasetPath string, sampleSize int) ([]string, [][]string, error) {
+	file, err := os.Open(datasetPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open data file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read header from data file")
+	}
+
+	columns := make([][]string, len(header))
+	for row := 0; row < sampleSize; row++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read line from data file")
+		}
+		for i := range header {
+			if i < len(line) {
+				value := strings.TrimSpace(line[i])
+				if value != "" {
+					columns[i] = append(columns[i], value)
+				}
+			}
+		}
+	}
+
+	return header, columns, nil
+}
+
+// classifyColumn scores each candidate type by the share of sampled values
+// matching it, returning the types with a non zero score from most to least
+// probable. The string type gets whatever share the best match leaves.
+func classifyColumn(name string, values []string) ([]string, []float64) {
+	scores := map[string]float64{}
+	if len(values) > 0 {
+		isLatitude, isLongitude := false, false
+		for _, token := range nameTokens(name) {
+			isLatitude = isLatitude || latitudeTokens[token]
+			isLongitude = isLongitude || longitudeTokens[token]
+		}
+
+		counts := map[string]int{}
+		distinct := map[string]bool{}
+		distinctBoolean := map[string]bool{}
+		for _, value := range values {
+			distinct[value] = true
+			lower := strings.ToLower(value)
+			if booleanValues[lower] {
+				counts[model.BoolType]++
+				distinctBoolean[lower] = true
+			}
+			if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+				counts[model.IntegerType]++
+			}
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				counts[model.RealType]++
+				if isLatitude && f >= -90 && f <= 90 {
+					counts[model.LatitudeType]++
+				}
+				if isLongitude && f >= -180 && f <= 180 {
+					counts[model.LongitudeType]++
+				}
+			}
+			if isDateTime(value) {
+				counts[model.DateTimeType]++
+			}
+			if emailRegex.MatchString(value) {
+				counts[model.EmailType]++
+			}
+			if isURI(value) {
+				counts[model.URIType]++
+			}
+		}
+
+		total := float64(len(values))
+		for typ, count := range counts {
+			scores[typ] = float64(count) / total
+		}
+		// booleans only take two values, which rules out most integer columns
+		if len(distinctBoolean) > 2 {
+			delete(scores, model.BoolType)
+		}
+		ratio := float64(len(distinct)) / total
+		if len(distinct) <= categoricalMaxValues && ratio <= categoricalMaxRatio {
+			// repeated booleans, numbers and dates are not categories, so the
+			// score is scaled to stay below the best match once ingest
+			// applies its weighting
+			categorical := 1 - ratio
+			if matched := highestScore(scores); matched > 0 {
+				categorical = categorical * matched / classifier.NonBasicTypeWeight
+			}
+			scores[model.CategoricalType] = categorical
+		}
+	}
+	scores[model.StringType] = 1 - highestScore(scores)
+
+	labels := []string{}
+	for _, typ := range localTypePrecedence {
+		if scores[typ] > 0 {
+			labels = append(labels, typ)
+		}
+	}
+	if len(labels) == 0 {
+		labels = append(labels, model.StringType)
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return scores[labels[i]] > scores[labels[j]]
+	})
+
+	probabilities := make([]float64, len(labels))
+	for i, typ := range labels {
+		probabilities[i] = scores[typ]
+	}
+
+	return labels, probabilities
+}
+
+func highestScore(scores map[string]float64) float64 {
+	best := 0.0
+	for _, score := range scores {
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// nameTokens splits a column name into lower case words on separators, case
+// changes and digits, so that 'pickupLat' and 'lat_1' hold 'lat' but
+// 'population' does not.
+func nameTokens(name string) []string {
+	tokens := []string{}
+	var token []rune
+	var previous rune
+	for _, r := range name {
+		split := !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		boundary := len(token) > 0 && ((unicode.IsUpper(r) && unicode.IsLower(previous)) ||
+			unicode.IsDigit(r) != unicode.IsDigit(previous))
+		if (split || boundary) && len(token) > 0 {
+			tokens = append(tokens, strings.ToLower(string(token)))
+			token = nil
+		}
+		if !split {
+			token = append(token, r)
+		}
+		previous = r
+	}
+	if len(token) > 0 {
+		tokens = append(tokens, strings.ToLower(string(token)))
+	}
+
+	return tokens
+}
+
+func isDateTime(value string) bool {
+	for _, layout := range dateTimeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isURI(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "ftp") && u.Host != ""
+}
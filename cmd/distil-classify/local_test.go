@@ -0,0 +1,182 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+)
+
+func TestNameTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"lat", []string{"lat"}},
+		{"pickup_lat", []string{"pickup", "lat"}},
+		{"pickup-Latitude", []string{"pickup", "latitude"}},
+		{"pickup lng", []string{"pickup", "lng"}},
+		{"pickupLat", []string{"pickup", "lat"}},
+		{"lat1", []string{"lat", "1"}},
+		{"LAT", []string{"lat"}},
+		{"population", []string{"population"}},
+		{"__", []string{}},
+	}
+	for _, test := range tests {
+		if actual := nameTokens(test.name); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("nameTokens(%q) = %q, expected %q", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestClassifyColumn(t *testing.T) {
+	tests := []struct {
+		name     string
+		column   string
+		values   []string
+		expected []string
+	}{
+		{"no values", "a", []string{}, []string{model.StringType}},
+		{"booleans", "flag", []string{"true", "false", "true", "false"},
+			[]string{model.BoolType, model.CategoricalType}},
+		{"zero one booleans", "flag", []string{"0", "1", "1", "0", "1", "0"},
+			[]string{model.BoolType, model.IntegerType, model.RealType, model.CategoricalType}},
+		{"integers", "count", []string{"1", "2", "3", "4"},
+			[]string{model.IntegerType, model.RealType, model.BoolType}},
+		{"reals", "price", []string{"1.5", "2.25", "3", "4.75"},
+			[]string{model.RealType, model.IntegerType}},
+		{"latitude after reals", "pickup_lat", []string{"45.1", "45.2", "45.3", "45.4"},
+			[]string{model.RealType, model.LatitudeType}},
+		{"longitude after reals", "dropoffLongitude", []string{"-73.9", "-73.8", "-73.7", "-73.6"},
+			[]string{model.RealType, model.LongitudeType}},
+		{"latitude out of range", "lat", []string{"45.1", "95.2", "45.3", "45.4"},
+			[]string{model.RealType, model.LatitudeType}},
+		{"word containing lat", "population", []string{"45.1", "45.2", "45.3", "45.4"},
+			[]string{model.RealType}},
+		{"word containing lon", "balloon", []string{"45.1", "45.2", "45.3", "45.4"},
+			[]string{model.RealType}},
+		{"dates", "when", []string{"2020-01-01", "2020-01-02", "2020-01-03", "2020-01-04"},
+			[]string{model.DateTimeType}},
+		{"emails", "contact", []string{"a@b.com", "c@d.org", "e@f.net", "g@h.io"},
+			[]string{model.EmailType}},
+		{"urls", "link", []string{"http://a.com", "https://b.org/x", "ftp://c.net", "http://d.io"},
+			[]string{model.URIType}},
+		{"categories", "colour", []string{"red", "blue", "red", "blue", "red", "blue"},
+			[]string{model.CategoricalType, model.StringType}},
+		{"text", "notes", []string{"alpha", "beta", "gamma", "delta"},
+			[]string{model.StringType}},
+	}
+	for _, test := range tests {
+		labels, probabilities := classifyColumn(test.column, test.values)
+		if !reflect.DeepEqual(labels, test.expected) {
+			t.Errorf("%s: classifyColumn(%q) = %v %v, expected %v", test.name, test.column, labels, probabilities, test.expected)
+			continue
+		}
+		if len(probabilities) != len(labels) {
+			t.Errorf("%s: %d probabilities for %d labels", test.name, len(probabilities), len(labels))
+			continue
+		}
+		for i := 1; i < len(probabilities); i++ {
+			if probabilities[i] > probabilities[i-1] {
+				t.Errorf("%s: probabilities %v are not in decreasing order", test.name, probabilities)
+			}
+		}
+	}
+}
+
+func TestClassifyColumnProbabilities(t *testing.T) {
+	labels, probabilities := classifyColumn("lat", []string{"45.5", "46.5", "x", "47.5"})
+	expected := map[string]float64{
+		model.RealType:     0.75,
+		model.LatitudeType: 0.75,
+		model.StringType:   0.25,
+	}
+	if len(labels) != len(expected) {
+		t.Fatalf("classifyColumn = %v %v, expected %v", labels, probabilities, expected)
+	}
+	for i, label := range labels {
+		if math.Abs(probabilities[i]-expected[label]) > 1e-9 {
+			t.Errorf("probability of %s = %f, expected %f", label, probabilities[i], expected[label])
+		}
+	}
+}
+
+func TestClassifyLocalIngestTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "classify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	columns := []struct {
+		name     string
+		values   []string
+		expected string
+	}{
+		{"flag", []string{"true", "false"}, model.BoolType},
+		{"zero_one", []string{"0", "1", "1"}, model.BoolType},
+		{"count", []string{"1", "2", "3", "4", "5"}, model.IntegerType},
+		{"price", []string{"1.5", "2.5", "3.25"}, model.RealType},
+		{"day", []string{"2020-01-01", "2020-01-02", "2020-01-03"}, model.DateTimeType},
+		{"pickup_lat", []string{"45.1", "45.2", "45.3", "45.4", "45.5", "45.6"}, model.LatitudeType},
+		{"colour", []string{"red", "blue", "green"}, model.CategoricalType},
+	}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	lines := []string{strings.Join(header, ",")}
+	// the values repeat so that every column has few distinct values
+	for row := 0; row < 60; row++ {
+		line := make([]string, len(columns))
+		for i, column := range columns {
+			line[i] = column.values[row%len(column.values)]
+		}
+		lines = append(lines, strings.Join(line, ","))
+	}
+	datasetPath := path.Join(dir, "learningData.csv")
+	err = ioutil.WriteFile(datasetPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	classificationPath := path.Join(dir, "classification.json")
+	err = classifyLocal(datasetPath, classificationPath, 100, variables.NewFilter(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ingest weights the suggested types before picking one
+	meta, err := metadata.LoadMetadataFromRawFile(datasetPath, classificationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range meta.GetMainDataResource().Variables {
+		if v.Type != columns[i].expected {
+			t.Errorf("%s ingested as %s, expected %s", columns[i].name, v.Type, columns[i].expected)
+		}
+	}
+}
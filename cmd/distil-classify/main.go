@@ -25,6 +25,8 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -50,7 +52,7 @@ func main() {
 	app.Name = "distil-classify"
 	app.Version = "0.1.0"
 	app.Usage = "Classify D3M merged datasets"
-	app.UsageText = "distil-classify --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-classify [--endpoint=<url> | --local] [--variables=<names>] [--exclude-variables=<names>] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -77,14 +79,51 @@ func main() {
 			Value: "",
 			Usage: "The classification output file path",
 		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "Infer the types from a sample of the dataset without a TA2, writing the classification next to the schema, or to the output path without a schema",
+		},
+		cli.IntFlag{
+			Name:  "sample-size",
+			Value: 1000,
+			Usage: "The number of rows sampled by the local classifier",
+		},
+		cli.StringFlag{
+			Name:  "review-output",
+			Value: "",
+			Usage: "The CSV file to write the suggested types and sample values of each variable to for review",
+		},
+		cli.IntFlag{
+			Name:  "review-top-k",
+			Value: 3,
+			Usage: "The number of suggested types listed for each variable in the review",
+		},
+		cli.IntFlag{
+			Name:  "review-samples",
+			Value: 5,
+			Usage: "The number of sample values listed for each variable in the review",
+		},
+		cli.StringFlag{
+			Name:  "variables",
+			Value: "",
+			Usage: "The comma separated variables to classify, defaulting to all of them",
+		},
+		cli.StringFlag{
+			Name:  "exclude-variables",
+			Value: "",
+			Usage: "The comma separated variables to leave out of the classification",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		if c.String("endpoint") == "" {
+		if c.String("endpoint") == "" && !c.Bool("local") {
 			return cli.NewExitError("missing commandline flag `--endpoint`", 1)
 		}
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
+		if c.Bool("local") && c.String("output") == "" && c.String("schema") == "" {
+			return cli.NewExitError("missing commandline flag `--schema`", 1)
+		}
 
 		endpoint := c.String("endpoint")
 		dataset := c.String("dataset")
@@ -92,47 +131,122 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
+		filter := variables.NewFilter(splitAndTrim(c.String("variables")), splitAndTrim(c.String("exclude-variables")))
 
-		err = env.Initialize(&config)
+		var classificationOutput string
+		if c.Bool("local") {
+			log.Infof("Using local classifier")
+			classificationOutput, err = localClassificationPath(schemaPath, output)
+			if err == nil {
+				err = classifyLocal(dataset, classificationOutput, c.Int("sample-size"), filter)
+			}
+		} else {
+			classificationOutput, err = classifyRemote(endpoint, dataset, schemaPath, input, output, filter)
+		}
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		ingestConfig := task.NewConfig(config)
+		log.Infof("Classification for `%s` successful", working.SourcePath(classificationOutput))
 
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		if c.String("review-output") != "" {
+			err = writeReview(c.String("review-output"), classificationOutput, dataset, schemaPath, c.Int("review-top-k"), c.Int("review-samples"))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("Type review written to `%s`", c.String("review-output"))
 		}
-		defer client.Close()
-		task.SetClient(client)
 
-		// classify the file
-		classificationOutput, err := task.Classify(schemaPath, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Classification for `%s` successful", classificationOutput)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// localClassificationPath is where the local classifier writes its result,
+// which is where the TA2 classification ends up so the later steps find it.
+// Without a schema the output path is used.
+func localClassificationPath(schemaPath string, output string) (string, error) {
+	if schemaPath == "" {
+		return output, nil
+	}
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(schemaPath), task.NewConfig(config).ClassificationOutputPathRelative), nil
+}
+
+// classifyRemote runs the Simon pipeline through the TA2 and returns the path
+// of the classification it wrote. When only some variables are selected, the
+// pipeline runs on a copy of the dataset holding just those variables.
+func classifyRemote(endpoint string, dataset string, schemaPath string, input string, output string, filter *variables.Filter) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// classify the file
+	if filter.Empty() {
+		return task.Classify(schemaPath, dataset, ingestConfig)
+	}
+
+	// the copy sits next to the dataset so the TA2 can read it
+	datasetFolder := path.Dir(schemaPath)
+	subsetFolder := path.Join(path.Dir(datasetFolder), path.Base(datasetFolder)+"-variables")
+	defer os.RemoveAll(subsetFolder)
+	subset, err := variables.WriteSubset(schemaPath, filter, subsetFolder)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("classifying %d of %d variables", len(subset.Indices), subset.ColumnCount)
+
+	subsetClassification, err := task.Classify(subset.SchemaPath, dataset, ingestConfig)
+	if err != nil {
+		return "", err
+	}
+	classificationOutput := path.Join(datasetFolder, ingestConfig.ClassificationOutputPathRelative)
+	err = expandClassification(subsetClassification, classificationOutput, subset)
+	if err != nil {
+		return "", err
+	}
+
+	return classificationOutput, nil
+}
@@ -0,0 +1,223 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/classifier"
+)
+
+const (
+	// reviewSelectedColumn is the column read back by distil-ingest as the
+	// authoritative type of the variable
+	reviewSelectedColumn  = "selected_type"
+	reviewSampleSeparator = " | "
+	// reviewMaxSampleRows bounds the rows scanned for sample values
+	reviewMaxSampleRows = 10000
+)
+
+// writeReview writes a CSV listing every classified variable with its most
+// probable types and sample values, with the selected type column prefilled
+// with the type ingest would pick so it can be edited. Index variables are
+// left out since ingest sets their type.
+func writeReview(filename string, classificationPath string, datasetPath string, schemaPath string, topK int, sampleCount int) error {
+	b, err := ioutil.ReadFile(classificationPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to read classification file")
+	}
+	classification := &model.ClassificationData{}
+	err = json.Unmarshal(b, classification)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse classification file")
+	}
+
+	header, samples, err := readSamples(datasetPath, sampleCount)
+	if err != nil {
+		return err
+	}
+	indexes, err := indexVariables(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", filename)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	columns := []string{"variable", reviewSelectedColumn}
+	for i := 1; i <= topK; i++ {
+		columns = append(columns, fmt.Sprintf("type_%d", i), fmt.Sprintf("probability_%d", i))
+	}
+	columns = append(columns, "samples")
+	err = writer.Write(columns)
+	if err != nil {
+		return errors.Wrap(err, "unable to write review")
+	}
+
+	for i, name := range header {
+		if i >= len(classification.Labels) || i >= len(classification.Probabilities) {
+			break
+		}
+		if indexes[name] {
+			continue
+		}
+		types := rankTypes(classification.Labels[i], classification.Probabilities[i])
+		if len(types) == 0 {
+			// variables left out of the classification keep their schema type
+			continue
+		}
+
+		// types the review cannot read back are left to the classification
+		selected := types[0].Type
+		if model.MapTA2Type(selected) == "" {
+			selected = ""
+		}
+
+		row := []string{name, selected}
+		for j := 0; j < topK; j++ {
+			if j < len(types) {
+				row = append(row, types[j].Type, fmt.Sprintf("%.4f", types[j].Probability))
+			} else {
+				row = append(row, "", "")
+			}
+		}
+		row = append(row, strings.Join(samples[i], reviewSampleSeparator))
+
+		err = writer.Write(row)
+		if err != nil {
+			return errors.Wrap(err, "unable to write review")
+		}
+	}
+	writer.Flush()
+
+	return errors.Wrap(writer.Error(), "unable to write review")
+}
+
+// rankTypes pairs the labels with their probabilities and ranks them the way
+// ingest does, mapping the labels to distil types and weighting non basic
+// types, most probable first. The probabilities are left as classified.
+func rankTypes(labels []string, probabilities []float64) []*model.SuggestedType {
+	types := []*model.SuggestedType{}
+	weights := map[*model.SuggestedType]float64{}
+	for i, label := range labels {
+		if i >= len(probabilities) {
+			break
+		}
+		typ := &model.SuggestedType{
+			Type:        label,
+			Probability: probabilities[i],
+		}
+		if label == "int" {
+			typ.Type = model.IntegerType
+		}
+		weights[typ] = typ.Probability
+		if !model.IsBasicSimonType(label) {
+			weights[typ] = typ.Probability * classifier.NonBasicTypeWeight
+		}
+		types = append(types, typ)
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		return weights[types[i]] > weights[types[j]]
+	})
+
+	return types
+}
+
+// indexVariables reads the names of the index variables from the schema,
+// which always include the d3m index.
+func indexVariables(schemaPath string) (map[string]bool, error) {
+	indexes := map[string]bool{model.D3MIndexFieldName: true}
+	if schemaPath == "" {
+		return indexes, nil
+	}
+
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaPath, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load original schema file")
+	}
+	for _, v := range meta.GetMainDataResource().Variables {
+		if model.IsIndexRole(v.SelectedRole) {
+			indexes[v.HeaderName] = true
+		}
+	}
+
+	return indexes, nil
+}
+
+// readSamples reads the header of the dataset and up to count distinct non
+// empty values of each column.
+func readSamples(datasetPath string, count int) ([]string, [][]string, error) {
+	file, err := os.Open(datasetPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open data file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read header from data file")
+	}
+
+	samples := make([][]string, len(header))
+	seen := make([]map[string]bool, len(header))
+	for i := range seen {
+		seen[i] = map[string]bool{}
+	}
+	for row := 0; row < reviewMaxSampleRows; row++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read line from data file")
+		}
+
+		full := true
+		for i := range header {
+			if len(samples[i]) >= count {
+				continue
+			}
+			full = false
+			if i >= len(line) || line[i] == "" || seen[i][line[i]] {
+				continue
+			}
+			seen[i][line[i]] = true
+			samples[i] = append(samples[i], line[i])
+		}
+		if full {
+			break
+		}
+	}
+
+	return header, samples, nil
+}
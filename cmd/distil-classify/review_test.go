@@ -0,0 +1,110 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestRankTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        []string
+		probabilities []float64
+		expected      []string
+	}{
+		{"basic types", []string{"real", "integer"}, []float64{0.4, 0.6}, []string{"integer", "real"}},
+		{"weighted categorical", []string{"integer", "categorical"}, []float64{0.9, 0.7}, []string{"categorical", "integer"}},
+		{"weighted latitude tie", []string{"real", "latitude"}, []float64{1, 1}, []string{"latitude", "real"}},
+		{"int label", []string{"string", "int"}, []float64{0.6, 0.5}, []string{"integer", "string"}},
+		{"missing probability", []string{"string", "integer"}, []float64{1}, []string{"string"}},
+	}
+	for _, test := range tests {
+		types := rankTypes(test.labels, test.probabilities)
+		actual := []string{}
+		for _, typ := range types {
+			actual = append(actual, typ.Type)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: rankTypes = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestWriteReview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	datasetPath := path.Join(dir, "learningData.csv")
+	err = ioutil.WriteFile(datasetPath, []byte("d3mIndex,count,colour,shape\n0,1,red,a\n1,2,blue,b\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classification := &model.ClassificationData{
+		Labels: [][]string{
+			{"integer"},
+			{"int", "categorical"},
+			{"categorical", "string"},
+			{"unsupported"},
+		},
+		Probabilities: [][]float64{{1}, {1, 0.5}, {0.8, 1}, {1}},
+	}
+	b, err := json.Marshal(classification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classificationPath := path.Join(dir, "classification.json")
+	err = ioutil.WriteFile(classificationPath, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reviewPath := path.Join(dir, "review.csv")
+	err = writeReview(reviewPath, classificationPath, datasetPath, "", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(reviewPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"variable", reviewSelectedColumn, "type_1", "probability_1", "samples"},
+		{"count", "integer", "integer", "1.0000", "1"},
+		{"colour", "categorical", "categorical", "0.8000", "red"},
+		{"shape", "", "unsupported", "1.0000", "a"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("writeReview wrote %v, expected %v", lines, expected)
+	}
+}
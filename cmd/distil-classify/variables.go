@@ -0,0 +1,74 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+)
+
+// expandClassification maps the classification of a dataset subset back onto
+// the columns of the original dataset. Columns left out of the subset have no
+// suggested types, so they keep their schema type.
+func expandClassification(subsetPath string, outputPath string, subset *variables.Subset) error {
+	b, err := ioutil.ReadFile(subsetPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to read classification file")
+	}
+	subsetClassification := &model.ClassificationData{}
+	err = json.Unmarshal(b, subsetClassification)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse classification file")
+	}
+
+	classification := &model.ClassificationData{
+		Path:          subsetClassification.Path,
+		Labels:        make([][]string, subset.ColumnCount),
+		Probabilities: make([][]float64, subset.ColumnCount),
+	}
+	for i := range classification.Labels {
+		classification.Labels[i] = []string{}
+		classification.Probabilities[i] = []float64{}
+	}
+	for i, index := range subset.Indices {
+		if i < len(subsetClassification.Labels) && i < len(subsetClassification.Probabilities) && index < subset.ColumnCount {
+			classification.Labels[index] = subsetClassification.Labels[i]
+			classification.Probabilities[index] = subsetClassification.Probabilities[i]
+		}
+	}
+
+	bytes, err := json.MarshalIndent(classification, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize classification result")
+	}
+	err = os.MkdirAll(path.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create classification output directory")
+	}
+	err = ioutil.WriteFile(outputPath, bytes, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store classification result")
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil/api/serialization"
+	log "github.com/unchartedsoftware/plog"
+)
+
+// cleaningReportFile is the name of the change report written next to the
+// cleaned schema when no report path is given.
+const cleaningReportFile = "cleaningReport.csv"
+
+var ruleOrder = []string{ruleTrim, ruleNull, ruleCase, ruleNumeric, ruleDate}
+
+// ruleChanges counts the values of a variable a rule changed or failed to
+// parse, keeping the first value it changed and its fully cleaned value as an
+// example.
+type ruleChanges struct {
+	Changed int
+	Failed  int
+	Before  string
+	After   string
+}
+
+// cleanLocal applies the cleaning rules to every variable of the main data
+// resource other than the index, writing the data and schema where the
+// cleaning pipeline does along with a report of the changes made by each rule.
+func cleanLocal(schemaFile string, rules *cleaningRules, reportPath string) (string, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, true)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	lines = lines[1:]
+
+	changes := make([]map[string]*ruleChanges, len(mainDR.Variables))
+	for i, v := range mainDR.Variables {
+		changes[i] = map[string]*ruleChanges{}
+		if model.IsIndexRole(v.SelectedRole) || v.Key == model.D3MIndexFieldName {
+			continue
+		}
+
+		cleaner := rules.cleaner(v)
+		for _, line := range lines {
+			if v.Index >= len(line) {
+				continue
+			}
+			before := line[v.Index]
+			cleaned, changed, failed := cleaner.clean(before)
+			for _, rule := range changed {
+				c := changes[i][rule]
+				if c == nil {
+					c = &ruleChanges{Before: before, After: cleaned}
+					changes[i][rule] = c
+				}
+				c.Changed++
+			}
+			if failed != "" {
+				if changes[i][failed] == nil {
+					changes[i][failed] = &ruleChanges{}
+				}
+				changes[i][failed].Failed++
+			}
+			line[v.Index] = cleaned
+		}
+	}
+
+	outputData := path.Join(path.Dir(schemaFile), compute.D3MDataFolder, compute.D3MLearningData)
+	output := [][]string{mainDR.GenerateHeader()}
+	output = append(output, lines...)
+	datasetStorage := serialization.GetStorage(outputData)
+	err = datasetStorage.WriteData(outputData, output)
+	if err != nil {
+		return "", errors.Wrap(err, "error writing cleaned output")
+	}
+	mainDR.ResPath = outputData
+
+	err = datasetStorage.WriteMetadata(schemaFile, meta, true, false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to store cleaned schema")
+	}
+
+	if reportPath == "" {
+		reportPath = path.Join(path.Dir(schemaFile), cleaningReportFile)
+	}
+	err = writeCleaningReport(reportPath, mainDR.Variables, changes)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("cleaning report written to %s", reportPath)
+
+	return schemaFile, nil
+}
+
+// writeCleaningReport writes a row for every rule that changed or failed to
+// parse values of a variable.
+func writeCleaningReport(filename string, variables []*model.Variable, changes []map[string]*ruleChanges) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", filename)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"variable", "rule", "changed", "failed", "example_value", "example_cleaned"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write cleaning report")
+	}
+	for i, v := range variables {
+		for _, rule := range ruleOrder {
+			c := changes[i][rule]
+			if c == nil {
+				continue
+			}
+			err = writer.Write([]string{v.Key, rule, strconv.Itoa(c.Changed), strconv.Itoa(c.Failed), c.Before, c.After})
+			if err != nil {
+				return errors.Wrap(err, "unable to write cleaning report")
+			}
+		}
+	}
+	writer.Flush()
+
+	return errors.Wrap(writer.Error(), "unable to write cleaning report")
+}
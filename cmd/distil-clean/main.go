@@ -16,6 +16,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
@@ -25,6 +26,7 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -38,7 +40,7 @@ func main() {
 	app.Name = "distil-clean"
 	app.Version = "0.1.0"
 	app.Usage = "clean datasets"
-	app.UsageText = "distil-clean --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-clean [--endpoint=<url> | --local] [--rules=<filepath>] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath> [--output-format=csv|parquet]"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -65,14 +67,39 @@ func main() {
 			Value: "",
 			Usage: "The cleaned output file path",
 		},
+		cli.StringFlag{
+			Name:  "rules",
+			Value: "",
+			Usage: "A JSON or YAML file of cleaning rules applied before the cleaning pipeline",
+		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "Only apply the cleaning rules, without a TA2",
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Value: "",
+			Usage: "The CSV file to write the changes made by each cleaning rule to, defaulting to one next to the cleaned data",
+		},
+		cli.StringFlag{
+			Name:  "output-format",
+			Value: "csv",
+			Usage: "The format of the cleaned data, either csv or parquet",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		if c.String("endpoint") == "" {
+		if c.String("endpoint") == "" && !c.Bool("local") {
 			return cli.NewExitError("missing commandline flag `--endpoint`", 1)
 		}
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
+		if c.Bool("local") && c.String("rules") == "" {
+			return cli.NewExitError("missing commandline flag `--rules`", 1)
+		}
+		if c.String("output-format") != "csv" && c.String("output-format") != "parquet" {
+			return cli.NewExitError(fmt.Sprintf("unsupported output format `%s`", c.String("output-format")), 1)
+		}
 
 		output := filepath.Clean(c.String("output"))
 		schemaPath := c.String("schema")
@@ -80,47 +107,89 @@ func main() {
 		dataset := filepath.Clean(c.String("dataset"))
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
 
-		err = env.Initialize(&config)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		cleanOutput := schemaPath
+		if c.String("rules") != "" {
+			rules, err := loadCleaningRules(c.String("rules"))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			cleanOutput, err = cleanLocal(schemaPath, rules, c.String("report"))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("Cleaning rules applied to %s", cleanOutput)
 		}
-		ingestConfig := task.NewConfig(config)
 
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		if !c.Bool("local") {
+			cleanOutput, err = cleanRemote(endpoint, dataset, schemaPath, input, output)
+			if err != nil {
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
 		}
-		defer client.Close()
-		task.SetClient(client)
+		if c.String("output-format") == "parquet" {
+			parquetPath, err := parquet.WriteOutput(cleanOutput)
+			if err != nil {
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("Parquet data written to %s", working.SourcePath(parquetPath))
+		}
+		log.Infof("Cleaned data written to %s", working.SourcePath(cleanOutput))
 
-		// create featurizer
-		cleanOutput, err := task.Clean(schemaPath, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Cleaned data written to %s", cleanOutput)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// cleanRemote runs the cleaning pipeline through the TA2 and returns the path
+// of the schema it wrote.
+func cleanRemote(endpoint string, dataset string, schemaPath string, input string, output string) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// create featurizer
+	return task.Clean(schemaPath, dataset, ingestConfig)
+}
@@ -0,0 +1,329 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/configfile"
+)
+
+const (
+	ruleTrim    = "trim"
+	ruleNull    = "null"
+	ruleCase    = "case"
+	ruleNumeric = "numeric"
+	ruleDate    = "date"
+
+	caseLower = "lower"
+	caseUpper = "upper"
+	caseTitle = "title"
+
+	// dateOnlyOutput is used for dates without a time when no output layout is
+	// given, and dateTimeOutput for all others
+	dateOnlyOutput = "2006-01-02"
+	dateTimeOutput = "2006-01-02T15:04:05"
+)
+
+var (
+	// localeSeparators holds the decimal and thousands separators of the
+	// supported languages, and of the locales that differ from their language
+	localeSeparators = map[string][2]string{
+		"en":    {".", ","},
+		"fr":    {",", " "},
+		"de":    {",", "."},
+		"es":    {",", "."},
+		"it":    {",", "."},
+		"pt":    {",", "."},
+		"nl":    {",", "."},
+		"de_CH": {".", "'"},
+		"fr_CH": {".", "'"},
+		"it_CH": {".", "'"},
+	}
+
+	defaultDateLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006/01/02",
+		"01/02/2006",
+		"1/2/2006",
+		"01/02/2006 15:04:05",
+		"01-02-2006",
+		"02-Jan-2006",
+		"2 Jan 2006",
+		"Jan 2, 2006",
+		"January 2, 2006",
+		time.RFC1123,
+		time.RFC1123Z,
+	}
+)
+
+// cleaningRules is the content of a cleaning rules file. The null tokens and
+// whitespace trimming apply to every column, and column rules add to them.
+type cleaningRules struct {
+	NullTokens     []string       `json:"nullTokens" yaml:"nullTokens"`
+	TrimWhitespace bool           `json:"trimWhitespace" yaml:"trimWhitespace"`
+	Columns        []*columnRules `json:"columns" yaml:"columns"`
+}
+
+// columnRules cleans the variables matching either the variable name or the
+// pattern. Numbers are parsed using the separators of the locale unless they
+// are set, and dates using the given Go time layouts.
+type columnRules struct {
+	Variable           string   `json:"variable" yaml:"variable"`
+	Pattern            string   `json:"pattern" yaml:"pattern"`
+	NullTokens         []string `json:"nullTokens" yaml:"nullTokens"`
+	TrimWhitespace     *bool    `json:"trimWhitespace" yaml:"trimWhitespace"`
+	Case               string   `json:"case" yaml:"case"`
+	Numeric            bool     `json:"numeric" yaml:"numeric"`
+	Locale             string   `json:"locale" yaml:"locale"`
+	DecimalSeparator   string   `json:"decimalSeparator" yaml:"decimalSeparator"`
+	ThousandsSeparator string   `json:"thousandsSeparator" yaml:"thousandsSeparator"`
+	Date               bool     `json:"date" yaml:"date"`
+	DateFormats        []string `json:"dateFormats" yaml:"dateFormats"`
+	DateOutput         string   `json:"dateOutput" yaml:"dateOutput"`
+	regex              *regexp.Regexp
+}
+
+func (r *columnRules) matches(v *model.Variable) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(v.Key) || r.regex.MatchString(v.HeaderName)
+	}
+	return r.Variable == v.Key || r.Variable == v.HeaderName
+}
+
+// columnCleaner applies the rules of a single column to its values.
+type columnCleaner struct {
+	trim       bool
+	nullTokens map[string]bool
+	rules      *columnRules
+	decimal    string
+	thousands  string
+}
+
+// loadCleaningRules reads and validates a JSON or YAML cleaning rules file.
+func loadCleaningRules(filename string) (*cleaningRules, error) {
+	rules := &cleaningRules{}
+	err := configfile.Read(filename, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range rules.Columns {
+		if (r.Variable == "") == (r.Pattern == "") {
+			return nil, errors.Errorf("column rule %d must set exactly one of variable or pattern", i)
+		}
+		if r.Pattern != "" {
+			r.regex, err = regexp.Compile("^(?:" + r.Pattern + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern in column rule %d", i)
+			}
+		}
+		if r.Case != "" && r.Case != caseLower && r.Case != caseUpper && r.Case != caseTitle {
+			return nil, errors.Errorf("unsupported case '%s' in column rule %d", r.Case, i)
+		}
+		if r.Numeric && r.Date {
+			return nil, errors.Errorf("column rule %d cannot parse both numbers and dates", i)
+		}
+		if r.Locale != "" {
+			if _, ok := lookupLocaleSeparators(r.Locale); !ok {
+				return nil, errors.Errorf("unsupported locale '%s' in column rule %d", r.Locale, i)
+			}
+		}
+		if r.DecimalSeparator != "" && r.DecimalSeparator == r.ThousandsSeparator {
+			return nil, errors.Errorf("column rule %d uses '%s' as both decimal and thousands separator", i, r.DecimalSeparator)
+		}
+	}
+
+	return rules, nil
+}
+
+// lookupLocaleSeparators finds the separators of a locale such as 'de_CH' or
+// 'fr-CA', falling back to those of its language.
+func lookupLocaleSeparators(locale string) ([2]string, bool) {
+	parts := strings.FieldsFunc(locale, func(r rune) bool {
+		return r == '_' || r == '-'
+	})
+	if len(parts) == 0 {
+		return [2]string{}, false
+	}
+	language := strings.ToLower(parts[0])
+	if len(parts) > 1 {
+		if separators, ok := localeSeparators[language+"_"+strings.ToUpper(parts[1])]; ok {
+			return separators, true
+		}
+	}
+	separators, ok := localeSeparators[language]
+	return separators, ok
+}
+
+// cleaner builds the cleaner of the variable from the global rules and the
+// first column rule matching it.
+func (r *cleaningRules) cleaner(v *model.Variable) *columnCleaner {
+	c := &columnCleaner{
+		trim:       r.TrimWhitespace,
+		nullTokens: map[string]bool{},
+	}
+	for _, token := range r.NullTokens {
+		c.nullTokens[token] = true
+	}
+
+	for _, rules := range r.Columns {
+		if !rules.matches(v) {
+			continue
+		}
+		c.rules = rules
+		if rules.TrimWhitespace != nil {
+			c.trim = *rules.TrimWhitespace
+		}
+		for _, token := range rules.NullTokens {
+			c.nullTokens[token] = true
+		}
+		c.decimal, c.thousands = ".", ","
+		if rules.Locale != "" {
+			separators, _ := lookupLocaleSeparators(rules.Locale)
+			c.decimal, c.thousands = separators[0], separators[1]
+		}
+		if rules.DecimalSeparator != "" {
+			c.decimal = rules.DecimalSeparator
+			if rules.ThousandsSeparator == "" && c.thousands == c.decimal {
+				c.thousands = otherSeparator(c.decimal)
+			}
+		}
+		if rules.ThousandsSeparator != "" {
+			c.thousands = rules.ThousandsSeparator
+			if rules.DecimalSeparator == "" && c.decimal == c.thousands {
+				c.decimal = otherSeparator(c.thousands)
+			}
+		}
+		break
+	}
+
+	return c
+}
+
+// otherSeparator is the separator paired with a decimal or thousands
+// separator when only one of them is set.
+func otherSeparator(separator string) string {
+	if separator == "," {
+		return "."
+	}
+	return ","
+}
+
+// clean applies the rules to the value in order, returning the cleaned value
+// along with the rules that changed it and the rule that failed to parse it.
+// Null tokens are compared to the value without surrounding whitespace.
+func (c *columnCleaner) clean(value string) (string, []string, string) {
+	changed := []string{}
+	apply := func(rule string, cleaned string) {
+		if cleaned != value {
+			changed = append(changed, rule)
+			value = cleaned
+		}
+	}
+
+	if c.trim {
+		apply(ruleTrim, strings.TrimSpace(value))
+	}
+	if c.nullTokens[strings.TrimSpace(value)] {
+		apply(ruleNull, "")
+	}
+	if c.rules == nil || value == "" {
+		return value, changed, ""
+	}
+
+	switch c.rules.Case {
+	case caseLower:
+		apply(ruleCase, strings.ToLower(value))
+	case caseUpper:
+		apply(ruleCase, strings.ToUpper(value))
+	case caseTitle:
+		apply(ruleCase, strings.Title(strings.ToLower(value)))
+	}
+
+	if c.rules.Numeric {
+		number, ok := c.parseNumber(value)
+		if !ok {
+			return value, changed, ruleNumeric
+		}
+		apply(ruleNumeric, number)
+	}
+	if c.rules.Date {
+		date, ok := c.parseDate(value)
+		if !ok {
+			return value, changed, ruleDate
+		}
+		apply(ruleDate, date)
+	}
+
+	return value, changed, ""
+}
+
+// parseNumber rewrites the number with a '.' decimal separator and no
+// thousands separators.
+func (c *columnCleaner) parseNumber(value string) (string, bool) {
+	if c.decimal == c.thousands {
+		return value, false
+	}
+	number := strings.TrimSpace(value)
+	if c.thousands != "" {
+		number = strings.ReplaceAll(number, c.thousands, "")
+	}
+	if c.thousands == " " {
+		// spaces used as thousands separators are often non breaking
+		number = strings.NewReplacer(" ", "", " ", "").Replace(number)
+	}
+	if c.decimal != "." {
+		number = strings.ReplaceAll(number, c.decimal, ".")
+	}
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return value, false
+	}
+	return number, true
+}
+
+// parseDate rewrites the date using the output layout.
+func (c *columnCleaner) parseDate(value string) (string, bool) {
+	layouts := c.rules.DateFormats
+	if len(layouts) == 0 {
+		layouts = defaultDateLayouts
+	}
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch {
+		case c.rules.DateOutput != "":
+			return t.Format(c.rules.DateOutput), true
+		case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0:
+			return t.Format(dateOnlyOutput), true
+		default:
+			return t.Format(dateTimeOutput), true
+		}
+	}
+	return value, false
+}
@@ -0,0 +1,274 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		value     string
+		decimal   string
+		thousands string
+		expected  string
+		ok        bool
+	}{
+		{"1,234.5", ".", ",", "1234.5", true},
+		{" 42 ", ".", ",", "42", true},
+		{"1.234,5", ",", ".", "1234.5", true},
+		{"1 234,5", ",", " ", "1234.5", true},
+		{"1\u00a0234,5", ",", " ", "1234.5", true},
+		{"1\u202f234,5", ",", " ", "1234.5", true},
+		{"1'234.5", ".", "'", "1234.5", true},
+		{"-0.5e3", ".", ",", "-0.5e3", true},
+		{"1,5", ",", ".", "1.5", true},
+		{"1.5", ",", ".", "15", true},
+		{"1,5", ",", ",", "1,5", false},
+		{"12abc", ".", ",", "12abc", false},
+		{"", ".", ",", "", false},
+	}
+	for _, test := range tests {
+		c := &columnCleaner{decimal: test.decimal, thousands: test.thousands}
+		actual, ok := c.parseNumber(test.value)
+		if actual != test.expected || ok != test.ok {
+			t.Errorf("parseNumber(%q) with %q and %q = %q, %v, expected %q, %v",
+				test.value, test.decimal, test.thousands, actual, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name     string
+		cleaner  *columnCleaner
+		value    string
+		expected string
+		changed  []string
+		failed   string
+	}{
+		{
+			name:     "unchanged",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}},
+			value:    " a ",
+			expected: " a ",
+			changed:  []string{},
+		},
+		{
+			name:     "trim",
+			cleaner:  &columnCleaner{trim: true, nullTokens: map[string]bool{}},
+			value:    " a ",
+			expected: "a",
+			changed:  []string{ruleTrim},
+		},
+		{
+			name:     "null token ignores whitespace",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{"N/A": true}},
+			value:    " N/A ",
+			expected: "",
+			changed:  []string{ruleNull},
+		},
+		{
+			name:     "case",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, rules: &columnRules{Case: caseTitle}},
+			value:    "new YORK",
+			expected: "New York",
+			changed:  []string{ruleCase},
+		},
+		{
+			name: "trim and numeric",
+			cleaner: &columnCleaner{trim: true, nullTokens: map[string]bool{}, decimal: ",", thousands: ".",
+				rules: &columnRules{Numeric: true, TrimWhitespace: &yes}},
+			value:    " 1.234,5 ",
+			expected: "1234.5",
+			changed:  []string{ruleTrim, ruleNumeric},
+		},
+		{
+			name:     "numeric failure",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, decimal: ".", thousands: ",", rules: &columnRules{Numeric: true}},
+			value:    "twelve",
+			expected: "twelve",
+			changed:  []string{},
+			failed:   ruleNumeric,
+		},
+		{
+			name:     "null skips numeric",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{"-": true}, rules: &columnRules{Numeric: true}},
+			value:    "-",
+			expected: "",
+			changed:  []string{ruleNull},
+		},
+		{
+			name:     "numeric with decimal comma",
+			cleaner:  (&cleaningRules{Columns: []*columnRules{{Variable: "a", Numeric: true, DecimalSeparator: ","}}}).cleaner(&model.Variable{Key: "a"}),
+			value:    "1,5",
+			expected: "1.5",
+			changed:  []string{ruleNumeric},
+		},
+		{
+			name:     "numeric with thousands dot",
+			cleaner:  (&cleaningRules{Columns: []*columnRules{{Variable: "a", Numeric: true, ThousandsSeparator: "."}}}).cleaner(&model.Variable{Key: "a"}),
+			value:    "1.5",
+			expected: "15",
+			changed:  []string{ruleNumeric},
+		},
+		{
+			name:     "numeric with thousands dot and decimal comma",
+			cleaner:  (&cleaningRules{Columns: []*columnRules{{Variable: "a", Numeric: true, ThousandsSeparator: "."}}}).cleaner(&model.Variable{Key: "a"}),
+			value:    "1.234,5",
+			expected: "1234.5",
+			changed:  []string{ruleNumeric},
+		},
+		{
+			name:     "date",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, rules: &columnRules{Date: true}},
+			value:    "03/15/2020",
+			expected: "2020-03-15",
+			changed:  []string{ruleDate},
+		},
+		{
+			name:     "date with time",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, rules: &columnRules{Date: true}},
+			value:    "2020-03-15 10:30:00",
+			expected: "2020-03-15T10:30:00",
+			changed:  []string{ruleDate},
+		},
+		{
+			name:     "date with layouts",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, rules: &columnRules{Date: true, DateFormats: []string{"02.01.2006"}, DateOutput: "2006/01/02"}},
+			value:    "15.03.2020",
+			expected: "2020/03/15",
+			changed:  []string{ruleDate},
+		},
+		{
+			name:     "date failure",
+			cleaner:  &columnCleaner{nullTokens: map[string]bool{}, rules: &columnRules{Date: true}},
+			value:    "someday",
+			expected: "someday",
+			changed:  []string{},
+			failed:   ruleDate,
+		},
+	}
+	for _, test := range tests {
+		actual, changed, failed := test.cleaner.clean(test.value)
+		if actual != test.expected || !reflect.DeepEqual(changed, test.changed) || failed != test.failed {
+			t.Errorf("%s: clean(%q) = %q, %v, %q, expected %q, %v, %q",
+				test.name, test.value, actual, changed, failed, test.expected, test.changed, test.failed)
+		}
+	}
+}
+
+func TestLookupLocaleSeparators(t *testing.T) {
+	tests := []struct {
+		locale   string
+		expected [2]string
+		ok       bool
+	}{
+		{"de", [2]string{",", "."}, true},
+		{"de_DE", [2]string{",", "."}, true},
+		{"de_CH", [2]string{".", "'"}, true},
+		{"de-ch", [2]string{".", "'"}, true},
+		{"fr_CA", [2]string{",", " "}, true},
+		{"EN_us", [2]string{".", ","}, true},
+		{"ch", [2]string{}, false},
+		{"xx_CH", [2]string{}, false},
+		{"", [2]string{}, false},
+	}
+	for _, test := range tests {
+		actual, ok := lookupLocaleSeparators(test.locale)
+		if actual != test.expected || ok != test.ok {
+			t.Errorf("lookupLocaleSeparators(%q) = %v, %v, expected %v, %v", test.locale, actual, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestCleaner(t *testing.T) {
+	rules := &cleaningRules{
+		NullTokens:     []string{"NA"},
+		TrimWhitespace: true,
+		Columns: []*columnRules{
+			{Variable: "price", Numeric: true, Locale: "de_CH"},
+			{Variable: "price", Numeric: true, Locale: "fr"},
+			{Variable: "amount", Numeric: true, Locale: "fr", ThousandsSeparator: "."},
+			{Variable: "decimal", Numeric: true, DecimalSeparator: ","},
+			{Variable: "thousands", Numeric: true, ThousandsSeparator: "."},
+			{Variable: "swapped", Numeric: true, Locale: "de", DecimalSeparator: "."},
+			{Variable: "space", Numeric: true, ThousandsSeparator: " "},
+		},
+	}
+	tests := []struct {
+		variable  string
+		decimal   string
+		thousands string
+	}{
+		{"price", ".", "'"},
+		{"amount", ",", "."},
+		{"decimal", ",", "."},
+		{"thousands", ",", "."},
+		{"swapped", ".", ","},
+		{"space", ".", " "},
+		{"other", "", ""},
+	}
+	for _, test := range tests {
+		c := rules.cleaner(&model.Variable{Key: test.variable, HeaderName: test.variable})
+		if c.decimal != test.decimal || c.thousands != test.thousands || !c.trim || !c.nullTokens["NA"] {
+			t.Errorf("cleaner(%s) = %+v", test.variable, c)
+		}
+	}
+}
+
+func TestLoadCleaningRules(t *testing.T) {
+	folder, err := ioutil.TempDir("", "clean-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		valid   bool
+	}{
+		{"json", "rules.json", `{"nullTokens": ["NA"], "columns": [{"variable": "a", "numeric": true, "locale": "de_CH"}]}`, true},
+		{"yaml", "rules.yaml", "nullTokens: [NA]\ncolumns:\n  - pattern: a.*\n    case: lower\n", true},
+		{"unknown json field", "typo.json", `{"nullToken": ["NA"]}`, false},
+		{"unknown yaml field", "typo.yaml", "nullToken: [NA]\n", false},
+		{"unsupported locale", "locale.json", `{"columns": [{"variable": "a", "locale": "ch"}]}`, false},
+		{"variable and pattern", "both.json", `{"columns": [{"variable": "a", "pattern": "a"}]}`, false},
+		{"numeric and date", "parse.json", `{"columns": [{"variable": "a", "numeric": true, "date": true}]}`, false},
+		{"same separators", "separators.json", `{"columns": [{"variable": "a", "decimalSeparator": ",", "thousandsSeparator": ","}]}`, false},
+		{"unsupported case", "case.json", `{"columns": [{"variable": "a", "case": "camel"}]}`, false},
+	}
+	for _, test := range tests {
+		filename := path.Join(folder, test.file)
+		err := ioutil.WriteFile(filename, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = loadCleaningRules(filename)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: loadCleaningRules returned %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil/api/serialization"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	algorithmKMeans = "kmeans"
+	algorithmDBSCAN = "dbscan"
+	// algorithmSloth is the timeseries clustering primitive run by the TA2
+	algorithmSloth = "sloth"
+
+	kmeansMaxIterations = 100
+	// clusterVariableName is the variable holding the cluster of each row
+	clusterVariableName = model.ClusterVarPrefix + "tabular"
+	// noiseLabel is the label of the rows DBSCAN leaves out of every cluster
+	noiseLabel = "Other"
+)
+
+// localClusterParams are the settings of a local clustering run.
+type localClusterParams struct {
+	Algorithm string
+	K         int
+	Columns   []string
+	Seed      int64
+	Epsilon   float64
+	MinPoints int
+}
+
+// describe lists the settings that produced the clusters.
+func (p *localClusterParams) describe(variables []*model.Variable) string {
+	settings := []string{fmt.Sprintf("algorithm=%s", p.Algorithm)}
+	switch p.Algorithm {
+	case algorithmKMeans:
+		settings = append(settings, fmt.Sprintf("k=%d", p.K), fmt.Sprintf("seed=%d", p.Seed))
+	case algorithmDBSCAN:
+		settings = append(settings, fmt.Sprintf("eps=%g", p.Epsilon), fmt.Sprintf("min-points=%d", p.MinPoints))
+	}
+	columns := make([]string, len(variables))
+	for i, v := range variables {
+		columns[i] = v.Key
+	}
+	settings = append(settings, fmt.Sprintf("columns=%s", strings.Join(columns, ",")))
+
+	return strings.Join(settings, "; ")
+}
+
+// clusterLocal clusters the rows of the main data resource on its numeric and
+// one-hot encoded categorical columns, then appends the cluster of each row
+// as a new variable, writing the data and schema where ClusterDataset does.
+func clusterLocal(schemaFile string, params *localClusterParams) (string, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, true)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	lines = lines[1:]
+
+	variables, err := selectClusterVariables(mainDR.Variables, params.Columns)
+	if err != nil {
+		return "", err
+	}
+	points := encodeRows(lines, variables)
+	log.Infof("clustering %d rows on %d variables using %s", len(lines), len(variables), params.Algorithm)
+
+	var labels []int
+	switch params.Algorithm {
+	case algorithmKMeans:
+		if params.K < 1 {
+			return "", errors.Errorf("k must be at least 1")
+		}
+		labels = kmeans(points, params.K, rand.New(rand.NewSource(params.Seed)))
+	case algorithmDBSCAN:
+		labels = dbscan(points, params.Epsilon, params.MinPoints)
+	default:
+		return "", errors.Errorf("unsupported clustering algorithm '%s'", params.Algorithm)
+	}
+
+	// the settings are kept in the description so they can be traced after ingest
+	clusterVariable := model.NewVariable(len(mainDR.Variables), clusterVariableName, "cluster", "", "", model.CategoricalType,
+		model.CategoricalType, params.describe(variables), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false)
+	mainDR.Variables = append(mainDR.Variables, clusterVariable)
+	for i, line := range lines {
+		lines[i] = append(line, clusterLabel(labels[i]))
+	}
+
+	outputData := path.Join(path.Dir(schemaFile), compute.D3MDataFolder, compute.D3MLearningData)
+	output := [][]string{mainDR.GenerateHeader()}
+	output = append(output, lines...)
+	datasetStorage := serialization.GetStorage(outputData)
+	err = datasetStorage.WriteData(outputData, output)
+	if err != nil {
+		return "", errors.Wrap(err, "error writing clustered output")
+	}
+	mainDR.ResPath = outputData
+
+	err = datasetStorage.WriteMetadata(schemaFile, meta, true, false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to store cluster schema")
+	}
+
+	return schemaFile, nil
+}
+
+// selectClusterVariables picks the named variables, or every numeric and
+// categorical variable that is not an index if none are named.
+func selectClusterVariables(variables []*model.Variable, columns []string) ([]*model.Variable, error) {
+	if len(columns) == 0 {
+		selected := []*model.Variable{}
+		for _, v := range variables {
+			if model.IsIndexRole(v.SelectedRole) || v.Type == model.IndexType {
+				continue
+			}
+			if model.IsNumerical(v.Type) || model.IsCategorical(v.Type) {
+				selected = append(selected, v)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, errors.Errorf("no numeric or categorical variables to cluster on")
+		}
+		return selected, nil
+	}
+
+	selected := make([]*model.Variable, len(columns))
+	for i, column := range columns {
+		for _, v := range variables {
+			if v.Key == column || v.HeaderName == column {
+				selected[i] = v
+				break
+			}
+		}
+		if selected[i] == nil {
+			return nil, errors.Errorf("variable '%s' not found", column)
+		}
+	}
+	return selected, nil
+}
+
+// encodeRows turns the rows into points, standardizing numeric variables and
+// one-hot encoding all other variables. Missing numeric values are replaced
+// by the mean.
+func encodeRows(lines [][]string, variables []*model.Variable) [][]float64 {
+	points := make([][]float64, len(lines))
+	for _, v := range variables {
+		values := make([]string, len(lines))
+		for i, line := range lines {
+			if v.Index < len(line) {
+				values[i] = strings.TrimSpace(line[v.Index])
+			}
+		}
+
+		if model.IsNumerical(v.Type) {
+			column := standardize(values)
+			for i := range points {
+				points[i] = append(points[i], column[i])
+			}
+			continue
+		}
+
+		categories := map[string]int{}
+		for _, value := range values {
+			if _, ok := categories[value]; !ok {
+				categories[value] = len(categories)
+			}
+		}
+		for i, value := range values {
+			oneHot := make([]float64, len(categories))
+			oneHot[categories[value]] = 1
+			points[i] = append(points[i], oneHot...)
+		}
+	}
+
+	return points
+}
+
+func standardize(values []string) []float64 {
+	parsed := make([]float64, len(values))
+	valid := make([]bool, len(values))
+	var n, sum, sumSquares float64
+	for i, value := range values {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		parsed[i] = f
+		valid[i] = true
+		n++
+		sum += f
+		sumSquares += f * f
+	}
+	if n == 0 {
+		return make([]float64, len(values))
+	}
+
+	mean := sum / n
+	std := math.Sqrt(math.Max(0, sumSquares/n-mean*mean))
+	standardized := make([]float64, len(values))
+	for i := range values {
+		if valid[i] && std > 0 {
+			standardized[i] = (parsed[i] - mean) / std
+		}
+	}
+	return standardized
+}
+
+// kmeans assigns the points to k clusters, seeding the centers using the
+// k-means++ scheme.
+func kmeans(points [][]float64, k int, rng *rand.Rand) []int {
+	labels := make([]int, len(points))
+	if len(points) == 0 {
+		return labels
+	}
+	if k > len(points) {
+		k = len(points)
+	}
+
+	centers := [][]float64{points[rng.Intn(len(points))]}
+	distances := make([]float64, len(points))
+	for len(centers) < k {
+		total := 0.0
+		for i, p := range points {
+			distances[i] = math.MaxFloat64
+			for _, c := range centers {
+				distances[i] = math.Min(distances[i], squaredDistance(p, c))
+			}
+			total += distances[i]
+		}
+		if total == 0 {
+			break
+		}
+		target := rng.Float64() * total
+		next := len(points) - 1
+		for i, d := range distances {
+			target -= d
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, points[next])
+	}
+
+	for iteration := 0; iteration < kmeansMaxIterations; iteration++ {
+		changed := false
+		for i, p := range points {
+			best := 0
+			bestDistance := math.MaxFloat64
+			for j, c := range centers {
+				if d := squaredDistance(p, c); d < bestDistance {
+					best = j
+					bestDistance = d
+				}
+			}
+			if labels[i] != best {
+				labels[i] = best
+				changed = true
+			}
+		}
+		if !changed && iteration > 0 {
+			break
+		}
+
+		sums := make([][]float64, len(centers))
+		counts := make([]int, len(centers))
+		for i, p := range points {
+			if sums[labels[i]] == nil {
+				sums[labels[i]] = make([]float64, len(p))
+			}
+			for d, value := range p {
+				sums[labels[i]][d] += value
+			}
+			counts[labels[i]]++
+		}
+		for j := range centers {
+			// empty clusters keep their previous center
+			if counts[j] == 0 {
+				continue
+			}
+			for d := range sums[j] {
+				sums[j][d] /= float64(counts[j])
+			}
+			centers[j] = sums[j]
+		}
+	}
+
+	return labels
+}
+
+// dbscan clusters the points by density, labelling points that belong to no
+// cluster with -1.
+func dbscan(points [][]float64, epsilon float64, minPoints int) []int {
+	const unvisited = -2
+	labels := make([]int, len(points))
+	for i := range labels {
+		labels[i] = unvisited
+	}
+	epsilonSquared := epsilon * epsilon
+
+	neighbours := func(i int) []int {
+		found := []int{}
+		for j, p := range points {
+			if squaredDistance(points[i], p) <= epsilonSquared {
+				found = append(found, j)
+			}
+		}
+		return found
+	}
+
+	cluster := 0
+	for i := range points {
+		if labels[i] != unvisited {
+			continue
+		}
+		seeds := neighbours(i)
+		if len(seeds) < minPoints {
+			labels[i] = -1
+			continue
+		}
+
+		labels[i] = cluster
+		for s := 0; s < len(seeds); s++ {
+			j := seeds[s]
+			if labels[j] == -1 {
+				labels[j] = cluster
+			}
+			if labels[j] != unvisited {
+				continue
+			}
+			labels[j] = cluster
+			if expanded := neighbours(j); len(expanded) >= minPoints {
+				seeds = append(seeds, expanded...)
+			}
+		}
+		cluster++
+	}
+
+	return labels
+}
+
+func squaredDistance(a []float64, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+// clusterLabel names clusters the same way as the clustering pipelines.
+func clusterLabel(label int) string {
+	if label < 0 {
+		return noiseLabel
+	}
+	if label < 26 {
+		return fmt.Sprintf("Pattern %c", 'A'+label)
+	}
+	return fmt.Sprintf("Pattern %d", label+1)
+}
@@ -0,0 +1,153 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// samePartition checks that the labels group the points the same way as the
+// expected labels, whatever the numbering of the clusters. Negative expected
+// labels must be matched exactly since they mark noise.
+func samePartition(labels []int, expected []int) bool {
+	if len(labels) != len(expected) {
+		return false
+	}
+	mapping := map[int]int{}
+	used := map[int]bool{}
+	for i, e := range expected {
+		if e < 0 || labels[i] < 0 {
+			if e != labels[i] {
+				return false
+			}
+			continue
+		}
+		if m, ok := mapping[e]; ok {
+			if m != labels[i] {
+				return false
+			}
+			continue
+		}
+		if used[labels[i]] {
+			return false
+		}
+		mapping[e] = labels[i]
+		used[labels[i]] = true
+	}
+	return true
+}
+
+func TestKMeans(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   [][]float64
+		k        int
+		expected []int
+	}{
+		{"empty", [][]float64{}, 2, []int{}},
+		{"single cluster", [][]float64{{0}, {1}, {2}}, 1, []int{0, 0, 0}},
+		{"two groups", [][]float64{{0, 0}, {0.1, 0}, {0, 0.1}, {10, 10}, {10.1, 10}, {10, 10.1}}, 2, []int{0, 0, 0, 1, 1, 1}},
+		{"three groups", [][]float64{{0}, {0.2}, {5}, {5.2}, {10}, {10.2}}, 3, []int{0, 0, 1, 1, 2, 2}},
+		{"more clusters than points", [][]float64{{0}, {10}}, 5, []int{0, 1}},
+		{"identical points", [][]float64{{1, 1}, {1, 1}, {1, 1}}, 3, []int{0, 0, 0}},
+	}
+	for _, test := range tests {
+		for seed := int64(0); seed < 5; seed++ {
+			labels := kmeans(test.points, test.k, rand.New(rand.NewSource(seed)))
+			if !samePartition(labels, test.expected) {
+				t.Errorf("%s (seed %d): kmeans = %v, expected the partition %v", test.name, seed, labels, test.expected)
+			}
+		}
+	}
+}
+
+func TestKMeansSeeded(t *testing.T) {
+	points := [][]float64{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		points = append(points, []float64{rng.Float64() * 10, rng.Float64() * 10})
+	}
+	first := kmeans(points, 4, rand.New(rand.NewSource(7)))
+	second := kmeans(points, 4, rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("kmeans with the same seed gave different labels")
+	}
+}
+
+func TestDBSCAN(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    [][]float64
+		epsilon   float64
+		minPoints int
+		expected  []int
+	}{
+		{"empty", [][]float64{}, 1, 2, []int{}},
+		{"two groups and noise", [][]float64{{0}, {0.5}, {1}, {10}, {10.5}, {11}, {50}}, 0.6, 2, []int{0, 0, 0, 1, 1, 1, -1}},
+		{"chain", [][]float64{{0}, {1}, {2}, {3}, {4}}, 1, 2, []int{0, 0, 0, 0, 0}},
+		{"all noise", [][]float64{{0}, {5}, {10}}, 1, 2, []int{-1, -1, -1}},
+		{"border point", [][]float64{{0}, {0.5}, {1}, {1.9}}, 1, 3, []int{0, 0, 0, 0}},
+		{"min points counts the point", [][]float64{{0, 0}, {3, 4}}, 5, 2, []int{0, 0}},
+		{"single point cluster", [][]float64{{0}}, 1, 1, []int{0}},
+	}
+	for _, test := range tests {
+		labels := dbscan(test.points, test.epsilon, test.minPoints)
+		if !samePartition(labels, test.expected) {
+			t.Errorf("%s: dbscan = %v, expected the partition %v", test.name, labels, test.expected)
+		}
+	}
+}
+
+func TestStandardize(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected []float64
+	}{
+		{[]string{"1", "3"}, []float64{-1, 1}},
+		{[]string{"1", "", "3"}, []float64{-1, 0, 1}},
+		{[]string{"2", "2"}, []float64{0, 0}},
+		{[]string{"a", "b"}, []float64{0, 0}},
+	}
+	for _, test := range tests {
+		actual := standardize(test.values)
+		for i := range actual {
+			if math.Abs(actual[i]-test.expected[i]) > 1e-9 {
+				t.Errorf("standardize(%v) = %v, expected %v", test.values, actual, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestClusterLabel(t *testing.T) {
+	tests := []struct {
+		label    int
+		expected string
+	}{
+		{-1, noiseLabel},
+		{0, "Pattern A"},
+		{25, "Pattern Z"},
+		{26, "Pattern 27"},
+	}
+	for _, test := range tests {
+		if actual := clusterLabel(test.label); actual != test.expected {
+			t.Errorf("clusterLabel(%d) = %s, expected %s", test.label, actual, test.expected)
+		}
+	}
+}
@@ -16,19 +16,40 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"runtime"
+	"strings"
 
 	"github.com/pkg/errors"
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
+	"github.com/uncharted-distil/distil/api/serialization"
 	"github.com/uncharted-distil/distil/api/task"
 )
 
+func splitAndTrim(arg string) []string {
+	var res []string
+	if arg == "" {
+		return res
+	}
+	split := strings.Split(arg, ",")
+	for _, str := range split {
+		res = append(res, strings.TrimSpace(str))
+	}
+	return res
+}
+
+// localFlags are the clustering settings only supported by local clustering.
+var localFlags = []string{"algorithm", "k", "columns", "seed", "eps", "min-points"}
+
 func main() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -37,7 +58,7 @@ func main() {
 	app.Name = "distil-cluster"
 	app.Version = "0.1.0"
 	app.Usage = "Cluster D3M datasets"
-	app.UsageText = "distil-cluster --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-cluster [--endpoint=<url> | --local [--algorithm=<name>] [--k=<count>] [--columns=<names>] [--seed=<seed>]] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -64,14 +85,59 @@ func main() {
 			Value: "",
 			Usage: "The clustering input path",
 		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "Cluster the rows without a TA2, appending the cluster of each row to the dataset",
+		},
+		cli.StringFlag{
+			Name:  "algorithm",
+			Value: algorithmKMeans,
+			Usage: "The local clustering algorithm, either kmeans or dbscan - the TA2 always clusters timeseries using Sloth",
+		},
+		cli.IntFlag{
+			Name:  "k",
+			Value: 5,
+			Usage: "The number of clusters found by kmeans",
+		},
+		cli.StringFlag{
+			Name:  "columns",
+			Value: "",
+			Usage: "A comma separated list of the variables to cluster on - defaults to every numeric and categorical variable",
+		},
+		cli.Int64Flag{
+			Name:  "seed",
+			Value: 0,
+			Usage: "The random seed used to pick the initial kmeans centers",
+		},
+		cli.Float64Flag{
+			Name:  "eps",
+			Value: 0.5,
+			Usage: "The neighbourhood radius used by dbscan, in standard deviations",
+		},
+		cli.IntFlag{
+			Name:  "min-points",
+			Value: 5,
+			Usage: "The number of neighbours a dbscan core point needs",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		if c.String("endpoint") == "" {
+		if c.String("endpoint") == "" && !c.Bool("local") {
 			return cli.NewExitError("missing commandline flag `--endpoint`", 1)
 		}
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
+		if c.Bool("local") && c.String("schema") == "" {
+			return cli.NewExitError("missing commandline flag `--schema`", 1)
+		}
+		if !c.Bool("local") {
+			// the clustering pipelines run by the TA2 take no settings
+			for _, flag := range localFlags {
+				if c.IsSet(flag) {
+					return cli.NewExitError(fmt.Sprintf("commandline flag `--%s` requires `--local`", flag), 1)
+				}
+			}
+		}
 
 		endpoint := c.String("endpoint")
 		datasetPath := c.String("dataset")
@@ -79,47 +145,114 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, datasetPath)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, datasetPath = working.SchemaPath, working.DataPath
 
-		err = env.Initialize(&config)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		var clusterPath string
+		if c.Bool("local") {
+			clusterPath, err = clusterLocal(schemaPath, &localClusterParams{
+				Algorithm: strings.ToLower(c.String("algorithm")),
+				K:         c.Int("k"),
+				Columns:   splitAndTrim(c.String("columns")),
+				Seed:      c.Int64("seed"),
+				Epsilon:   c.Float64("eps"),
+				MinPoints: c.Int("min-points"),
+			})
+		} else {
+			clusterPath, err = clusterRemote(endpoint, datasetPath, schemaPath, input, output)
 		}
-		ingestConfig := task.NewConfig(config)
-
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		defer client.Close()
-		task.SetClient(client)
+		log.Infof("Clustered data written to %s", working.SourcePath(clusterPath))
 
-		// create featurizer
-		clusterPath, err := task.ClusterDataset(schemaPath, datasetPath, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Clustered data written to %s", clusterPath)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// clusterRemote runs the clustering pipelines through the TA2 and returns the
+// path of the schema it wrote.
+func clusterRemote(endpoint string, datasetPath string, schemaPath string, input string, output string) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// create featurizer
+	clusterPath, err := task.ClusterDataset(schemaPath, datasetPath, ingestConfig)
+	if err != nil {
+		return "", err
+	}
+
+	err = recordRemoteClusterParams(clusterPath)
+	if err != nil {
+		return "", err
+	}
+
+	return clusterPath, nil
+}
+
+// recordRemoteClusterParams describes the settings of the cluster variables
+// added by the TA2 the same way as local clusters, so they can be traced
+// after ingest.
+func recordRemoteClusterParams(schemaPath string) error {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaPath, true)
+	if err != nil {
+		return errors.Wrap(err, "unable to load cluster schema")
+	}
+
+	recorded := false
+	for _, v := range meta.GetMainDataResource().Variables {
+		if strings.HasPrefix(v.Key, model.ClusterVarPrefix) && v.Description == "" {
+			v.Description = fmt.Sprintf("algorithm=%s; columns=%s", algorithmSloth, v.OriginalVariable)
+			recorded = true
+		}
+	}
+	if !recorded {
+		return nil
+	}
+
+	err = serialization.GetStorage(schemaPath).WriteMetadata(schemaPath, meta, true, false)
+	if err != nil {
+		return errors.Wrap(err, "unable to store cluster schema")
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+module github.com/uncharted-distil/distil-ingest/cmd/distil-export
+
+go 1.13
+
+require (
+	github.com/pkg/errors v0.9.1
+	github.com/uncharted-distil/distil v0.0.0-20210221181328-5e5b42f120fb
+	github.com/uncharted-distil/distil-compute v0.0.0-20210208222927-a7ae5d433614
+	github.com/unchartedsoftware/plog v0.0.0-20200807135627-83d59e50ced5
+	github.com/urfave/cli v1.22.5
+)
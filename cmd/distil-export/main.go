@@ -0,0 +1,246 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	elastic "github.com/uncharted-distil/distil/api/model/storage/elastic"
+	pg "github.com/uncharted-distil/distil/api/model/storage/postgres"
+	"github.com/uncharted-distil/distil/api/postgres"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+const (
+	metadataIndexName = "datasets"
+)
+
+func main() {
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	app := cli.NewApp()
+	app.Name = "distil-export"
+	app.Version = "0.1.0"
+	app.Usage = "Export ingested datasets to D3M dataset folders"
+	app.UsageText = "distil-export --dataset=<id> --output=<filepath> --es-endpoint=<url> --database=<name>"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "dataset",
+			Value: "",
+			Usage: "The id of the dataset to export",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Value: "",
+			Usage: "The D3M dataset folder to write",
+		},
+		cli.StringFlag{
+			Name:  "es-endpoint",
+			Value: "",
+			Usage: "The Elasticsearch endpoint",
+		},
+		cli.StringFlag{
+			Name:  "es-metadata-index",
+			Value: metadataIndexName,
+			Usage: "The Elasticsearch index to read metadata from",
+		},
+		cli.StringFlag{
+			Name:  "database",
+			Value: "",
+			Usage: "The postgres database to use",
+		},
+		cli.StringFlag{
+			Name:  "db-host",
+			Value: "localhost",
+			Usage: "The postgres database hostname - defaults to localhost",
+		},
+		cli.IntFlag{
+			Name:  "db-port",
+			Value: 5432,
+			Usage: "The postgres database port - defaults to 5432",
+		},
+		cli.StringFlag{
+			Name:  "db-user",
+			Value: "",
+			Usage: "The database user to use.",
+		},
+		cli.StringFlag{
+			Name:  "db-password",
+			Value: "",
+			Usage: "The database password to use for authentication.",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		if c.String("dataset") == "" {
+			return cli.NewExitError("missing commandline flag `--dataset`", 1)
+		}
+		if c.String("output") == "" {
+			return cli.NewExitError("missing commandline flag `--output`", 1)
+		}
+		if c.String("es-endpoint") == "" {
+			return cli.NewExitError("missing commandline flag `--es-endpoint`", 1)
+		}
+		if c.String("database") == "" {
+			return cli.NewExitError("missing commandline flag `--database`", 1)
+		}
+
+		dataset := c.String("dataset")
+		output := filepath.Clean(c.String("output"))
+
+		// initialize config
+		config, err := env.LoadConfig()
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		config.ElasticEndpoint = c.String("es-endpoint")
+		config.ESDatasetsIndex = c.String("es-metadata-index")
+		config.PostgresDatabase = c.String("database")
+		config.PostgresUser = c.String("db-user")
+		config.PostgresPassword = c.String("db-password")
+		config.PostgresHost = c.String("db-host")
+		config.PostgresPort = c.Int("db-port")
+
+		schemaPath, err := exportDataset(dataset, output, &config)
+		if err != nil {
+			log.Errorf("%+v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		log.Infof("Exported dataset written to %s", schemaPath)
+
+		return nil
+	}
+	// run app
+	app.Run(os.Args)
+}
+
+func exportDataset(dataset string, outputFolder string, config *env.Config) (string, error) {
+	esClientCtor := es.NewClient(config.ElasticEndpoint, false)
+	storageCtor := elastic.NewMetadataStorage(config.ESDatasetsIndex, false, esClientCtor)
+	storage, err := storageCtor()
+	if err != nil {
+		return "", err
+	}
+
+	postgresClientCtor := postgres.NewClient(config.PostgresHost, config.PostgresPort, config.PostgresUser, config.PostgresPassword,
+		config.PostgresDatabase, config.PostgresLogLevel, false)
+	postgresBatchClientCtor := postgres.NewClient(config.PostgresHost, config.PostgresPort, config.PostgresUser, config.PostgresPassword,
+		config.PostgresDatabase, "error", true)
+	dataStorageCtor := pg.NewDataStorage(postgresClientCtor, postgresBatchClientCtor, storageCtor)
+	dataStorage, err := dataStorageCtor()
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("reading metadata for dataset %s", dataset)
+	ds, err := storage.FetchDataset(dataset, true, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("reading data for dataset %s from %s", dataset, ds.StorageName)
+	data, err := dataStorage.FetchDataset(dataset, ds.StorageName, true, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	meta, data, err := buildExport(ds, data)
+	if err != nil {
+		return "", err
+	}
+
+	return writeExport(outputFolder, meta, data)
+}
+
+// buildExport lays out the exported dataset. The data columns drive the
+// variable order of the main data resource, and the groupings that do not map
+// to a column follow them so their grouping is written to the schema.
+func buildExport(ds *api.Dataset, data [][]string) (*model.Metadata, [][]string, error) {
+	meta := ds.ToMetadata()
+
+	variables := map[string]*model.Variable{}
+	for _, v := range ds.Variables {
+		variables[v.Key] = v
+	}
+	var header []string
+	if len(data) > 0 {
+		header = data[0]
+	} else {
+		for _, v := range ds.Variables {
+			if !v.IsGrouping() {
+				header = append(header, v.Key)
+			}
+		}
+		data = [][]string{header}
+	}
+	exportedVariables := make([]*model.Variable, len(header))
+	exported := map[string]bool{}
+	for i, key := range header {
+		v, ok := variables[key]
+		if !ok {
+			return nil, nil, errors.Errorf("column '%s' has no matching variable in the metadata", key)
+		}
+		v.Index = i
+		exportedVariables[i] = v
+		exported[key] = true
+	}
+
+	mainDR := meta.GetMainDataResource()
+	mainDR.Variables = exportedVariables
+	mainDR.ResPath = path.Join(compute.D3MDataFolder, compute.D3MLearningData)
+	data[0] = mainDR.GenerateHeader()
+
+	for _, v := range ds.Variables {
+		if v.IsGrouping() && !exported[v.Key] {
+			v.Index = len(mainDR.Variables)
+			mainDR.Variables = append(mainDR.Variables, v)
+		}
+	}
+
+	return meta, data, nil
+}
+
+// writeExport writes the data and schema of an exported dataset to the output
+// folder and returns the path of the schema.
+func writeExport(outputFolder string, meta *model.Metadata, data [][]string) (string, error) {
+	mainDR := meta.GetMainDataResource()
+	csvStorage := serialization.GetCSVStorage()
+	err := csvStorage.WriteData(path.Join(outputFolder, mainDR.ResPath), data)
+	if err != nil {
+		return "", err
+	}
+
+	schemaPath := path.Join(outputFolder, compute.D3MDataSchema)
+	err = csvStorage.WriteMetadata(schemaPath, meta, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	return schemaPath, nil
+}
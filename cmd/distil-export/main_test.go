@@ -0,0 +1,190 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	api "github.com/uncharted-distil/distil/api/model"
+)
+
+func testExportDataset() *api.Dataset {
+	index := model.NewVariable(0, model.D3MIndexFieldName, model.D3MIndexFieldName, model.D3MIndexFieldName, model.D3MIndexFieldName,
+		model.IntegerType, model.IntegerType, "", []string{model.RoleIndex}, model.VarDistilRoleIndex, nil, nil, false)
+	// verified at ingest from the original string type
+	lat := model.NewVariable(1, "lat", "lat", "lat", "lat", model.LatitudeType, model.StringType,
+		"", []string{model.RoleAttribute}, model.VarDistilRoleData, nil, nil, false)
+	lon := model.NewVariable(2, "lon", "lon", "lon", "lon", model.LongitudeType, model.StringType,
+		"", []string{model.RoleAttribute}, model.VarDistilRoleData, nil, nil, false)
+	point := model.NewVariable(3, "point", "point", "point", "point", model.GeoCoordinateType, model.GeoCoordinateType,
+		"", []string{model.RoleAttribute}, model.VarDistilRoleGrouping, nil, nil, false)
+	point.Grouping = &model.GeoCoordinateGrouping{
+		Grouping: model.Grouping{Type: model.GeoCoordinateType, IDCol: "point", Hidden: []string{"lat", "lon"}},
+		XCol:     "lon",
+		YCol:     "lat",
+	}
+
+	return &api.Dataset{
+		ID:          "export_test",
+		Name:        "export_test",
+		StorageName: "export_test",
+		Variables:   []*model.Variable{index, lat, lon, point},
+	}
+}
+
+// exportedSchema is the part of the exported schema the tests check.
+type exportedSchema struct {
+	DataResources []struct {
+		ResID   string `json:"resID"`
+		Columns []struct {
+			ColIndex int    `json:"colIndex"`
+			ColName  string `json:"colName"`
+			ColType  string `json:"colType"`
+		} `json:"columns"`
+	} `json:"dataResources"`
+}
+
+func TestExport(t *testing.T) {
+	folder, err := ioutil.TempDir("", "export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	// the stored data lists the columns in its own order
+	data := [][]string{{"d3mIndex", "lon", "lat"}, {"0", "2.35", "48.85"}, {"1", "-73.98", "40.75"}}
+	meta, data, err := buildExport(testExportDataset(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaPath, err := writeExport(folder, meta, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &exportedSchema{}
+	err = json.Unmarshal(b, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.DataResources) != 1 {
+		t.Fatalf("exported %d data resources, expected 1", len(schema.DataResources))
+	}
+	resource := schema.DataResources[0]
+
+	expectedTypes := []struct {
+		name string
+		typ  string
+	}{
+		{"d3mIndex", model.IntegerSchemaType},
+		{"lon", model.RealSchemaType},
+		{"lat", model.RealSchemaType},
+		{"point", model.MapSchemaType(model.GeoCoordinateType)},
+	}
+	if len(resource.Columns) != len(expectedTypes) {
+		t.Fatalf("exported %d columns, expected %d", len(resource.Columns), len(expectedTypes))
+	}
+	for i, expected := range expectedTypes {
+		column := resource.Columns[i]
+		if column.ColIndex != i || column.ColName != expected.name || column.ColType != expected.typ {
+			t.Errorf("column %d is %d %s %s, expected %d %s %s", i, column.ColIndex, column.ColName, column.ColType,
+				i, expected.name, expected.typ)
+		}
+	}
+
+	rows, err := ioutil.ReadFile(path.Join(folder, "tables", "learningData.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d3mIndex,lon,lat\n0,2.35,48.85\n1,-73.98,40.75\n"; string(rows) != expected {
+		t.Errorf("exported rows %q, expected %q", string(rows), expected)
+	}
+}
+
+func TestBuildExportUnknownColumn(t *testing.T) {
+	_, _, err := buildExport(testExportDataset(), [][]string{{"d3mIndex", "missing"}})
+	if err == nil {
+		t.Errorf("expected an error for a column without a variable")
+	}
+}
+
+func TestBuildExportEmptyData(t *testing.T) {
+	meta, data, err := buildExport(testExportDataset(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || len(data[0]) != 3 {
+		t.Errorf("expected a header of the 3 data columns, got %v", data)
+	}
+	if len(meta.GetMainDataResource().Variables) != 4 {
+		t.Errorf("expected 3 variables and 1 grouping, got %d variables", len(meta.GetMainDataResource().Variables))
+	}
+}
+
+func TestExportGroupingReload(t *testing.T) {
+	folder, err := ioutil.TempDir("", "export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ds := testExportDataset()
+	expected := ds.Variables[3].Grouping
+	data := [][]string{{"d3mIndex", "lat", "lon"}, {"0", "48.85", "2.35"}}
+	meta, data, err := buildExport(ds, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaPath, err := writeExport(folder, meta, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := metadata.LoadMetadataFromOriginalSchema(schemaPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := reloaded.Schema.Path("dataResources").Children()[0].Path("columns").Children()
+	variables := reloaded.GetMainDataResource().Variables
+	if len(variables) != 4 || len(columns) != 4 {
+		t.Fatalf("reloaded %d variables and %d columns, expected 4", len(variables), len(columns))
+	}
+	point := variables[3]
+	if point.Key != "point" || point.DistilRole != model.VarDistilRoleGrouping {
+		t.Errorf("reloaded variable %s with role %s, expected point with role %s", point.Key, point.DistilRole, model.VarDistilRoleGrouping)
+	}
+
+	// the loader only keeps the grouping field in the parsed schema
+	actual := &model.GeoCoordinateGrouping{}
+	err = json.Unmarshal(columns[3].Path(model.VarGroupingField).Bytes(), actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("reloaded grouping %+v, expected %+v", actual, expected)
+	}
+}
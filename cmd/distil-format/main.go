@@ -16,6 +16,7 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"runtime"
@@ -25,6 +26,8 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -50,7 +53,7 @@ func main() {
 	app.Name = "distil-format"
 	app.Version = "0.1.0"
 	app.Usage = "format to D3M datasets"
-	app.UsageText = "distil-format --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-format --endpoint=<url> [--variables=<names>] [--exclude-variables=<names>] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -77,6 +80,16 @@ func main() {
 			Value: "",
 			Usage: "The formatted output file path",
 		},
+		cli.StringFlag{
+			Name:  "variables",
+			Value: "",
+			Usage: "The comma separated variables to keep in the formatted dataset, defaulting to all of them",
+		},
+		cli.StringFlag{
+			Name:  "exclude-variables",
+			Value: "",
+			Usage: "The comma separated variables to drop from the formatted dataset",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		if c.String("endpoint") == "" {
@@ -92,6 +105,15 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
+		filter := variables.NewFilter(splitAndTrim(c.String("variables")), splitAndTrim(c.String("exclude-variables")))
+
 		// initialize config
 		log.Infof("Using TA2 interface at `%s` ", endpoint)
 		config, err := env.LoadConfig()
@@ -123,13 +145,39 @@ func main() {
 		defer client.Close()
 		task.SetClient(client)
 
+		// the format task writes in place, so the selected variables are
+		// formatted in a copy of the dataset next to it
+		if !filter.Empty() {
+			datasetFolder := path.Dir(schemaPath)
+			subsetFolder, err := ioutil.TempDir(path.Dir(datasetFolder), path.Base(datasetFolder)+"-variables-")
+			if err != nil {
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			subset, err := variables.WriteSubset(schemaPath, filter, subsetFolder)
+			if err != nil {
+				os.RemoveAll(subsetFolder)
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("formatting %d of %d variables", len(subset.Indices), subset.ColumnCount)
+			schemaPath = subset.SchemaPath
+			dataset = subset.DataPath
+		}
+
 		// create featurizer
 		formatPath, err := task.Format(schemaPath, dataset, ingestConfig)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Formatted data written to %s", formatPath)
+		log.Infof("Formatted data written to %s", working.SourcePath(formatPath))
+
+		err = working.Finish()
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
 
 		return nil
 	}
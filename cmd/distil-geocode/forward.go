@@ -0,0 +1,103 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+	"github.com/uncharted-distil/distil/api/serialization"
+	"github.com/uncharted-distil/distil/api/task"
+	log "github.com/unchartedsoftware/plog"
+)
+
+// geocodeSelected geocodes the selected location variables through the TA2,
+// following GeocodeForwardDataset but only running the geocoding pipeline on
+// the selected variables.
+func geocodeSelected(schemaFile string, dataset string, config *task.IngestTaskConfig, filter *variables.Filter) (string, error) {
+	meta, err := metadata.LoadMetadataFromClassification(schemaFile, path.Join(path.Dir(schemaFile), config.ClassificationOutputPathRelative), false, true)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	d3mIndexField := -1
+	for _, v := range mainDR.Variables {
+		if v.Key == model.D3MIndexFieldName {
+			d3mIndexField = v.Index
+		}
+	}
+	if d3mIndexField < 0 {
+		return "", errors.Errorf("dataset has no %s variable", model.D3MIndexFieldName)
+	}
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	lines = lines[1:]
+
+	colsToGeocode, err := selectGeocodeVariables(mainDR.Variables, filter)
+	if err != nil {
+		return "", err
+	}
+	if len(colsToGeocode) == 0 {
+		log.Infof("no location variables found to geocode")
+		return schemaFile, nil
+	}
+
+	for _, col := range colsToGeocode {
+		log.Infof("geocoding field %s", col.Key)
+		geocoded, err := task.GeocodeForward(path.Dir(schemaFile), dataset, col)
+		if err != nil {
+			return "", err
+		}
+
+		latName, lonName := fmt.Sprintf("_lat_%s", col.Key), fmt.Sprintf("_lon_%s", col.Key)
+		mainDR.Variables = append(mainDR.Variables,
+			model.NewVariable(len(mainDR.Variables), latName, "label", latName, latName, model.LatitudeType, model.LatitudeType,
+				fmt.Sprintf("latitude obtained from field %s", col.Key), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false),
+			model.NewVariable(len(mainDR.Variables)+1, lonName, "label", lonName, lonName, model.LongitudeType, model.LongitudeType,
+				fmt.Sprintf("longitude obtained from field %s", col.Key), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false),
+		)
+
+		// rows the pipeline drops get empty coordinates to keep columns aligned
+		byIndex := map[string]*task.GeocodedPoint{}
+		for _, gc := range geocoded {
+			byIndex[gc.D3MIndex] = gc
+		}
+		for i, line := range lines {
+			if gc, ok := byIndex[line[d3mIndexField]]; ok {
+				lines[i] = append(line, gc.Latitude, gc.Longitude)
+			} else {
+				lines[i] = append(line, "", "")
+			}
+		}
+	}
+
+	err = writeGeocodedDataset(schemaFile, meta, lines)
+	if err != nil {
+		return "", err
+	}
+
+	return schemaFile, nil
+}
@@ -0,0 +1,239 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// GeoNames dump columns used from the gazetteer.
+const (
+	geonamesName           = 1
+	geonamesASCIIName      = 2
+	geonamesAlternateNames = 3
+	geonamesLatitude       = 4
+	geonamesLongitude      = 5
+	geonamesCountryCode    = 8
+	geonamesAdmin1         = 10
+	geonamesAdmin2         = 11
+	geonamesPopulation     = 14
+	geonamesMinColumns     = 15
+
+	// maxEditDistance bounds the length difference of fuzzy matches
+	maxEditDistance = 2
+	// partialMatchConfidence is the confidence of a match on the first part
+	// of a comma separated value such as 'Paris, France'
+	partialMatchConfidence = 0.9
+)
+
+// place is a single entry of the gazetteer.
+type place struct {
+	Name       string
+	Latitude   string
+	Longitude  string
+	Country    string
+	Admin1     string
+	Admin2     string
+	Population int64
+}
+
+// gazetteer indexes places by their normalized names.
+type gazetteer struct {
+	places  []*place
+	names   map[string][]*place
+	buckets map[rune][]string
+}
+
+// loadGazetteer reads a GeoNames style tab separated file of places, indexing
+// each place under its name, ascii name and alternate names.
+func loadGazetteer(filename string) (*gazetteer, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open gazetteer %s", filename)
+	}
+	defer file.Close()
+
+	g := &gazetteer{
+		names:   map[string][]*place{},
+		buckets: map[rune][]string{},
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < geonamesMinColumns {
+			continue
+		}
+		p := &place{
+			Name:      fields[geonamesName],
+			Latitude:  fields[geonamesLatitude],
+			Longitude: fields[geonamesLongitude],
+			Country:   fields[geonamesCountryCode],
+			Admin1:    fields[geonamesAdmin1],
+			Admin2:    fields[geonamesAdmin2],
+		}
+		p.Population, _ = strconv.ParseInt(fields[geonamesPopulation], 10, 64)
+		g.places = append(g.places, p)
+
+		names := []string{fields[geonamesName], fields[geonamesASCIIName]}
+		if fields[geonamesAlternateNames] != "" {
+			names = append(names, strings.Split(fields[geonamesAlternateNames], ",")...)
+		}
+		for _, name := range names {
+			g.add(normalizePlaceName(name), p)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read gazetteer %s", filename)
+	}
+
+	return g, nil
+}
+
+func (g *gazetteer) add(name string, p *place) {
+	if name == "" {
+		return
+	}
+	existing := g.names[name]
+	for _, e := range existing {
+		if e == p {
+			return
+		}
+	}
+	if len(existing) == 0 {
+		initial := []rune(name)[0]
+		g.buckets[initial] = append(g.buckets[initial], name)
+	}
+	g.names[name] = append(existing, p)
+}
+
+// match finds the place best matching the value along with a confidence
+// between 0 and 1. Exact matches on the normalized name come first, then
+// matches on the first part of comma separated values, then names within a
+// small edit distance. Ties go to the most populous place.
+func (g *gazetteer) match(value string, minConfidence float64) (*place, float64) {
+	name := normalizePlaceName(value)
+	if name == "" {
+		return nil, 0
+	}
+	if places, ok := g.names[name]; ok {
+		return mostPopulous(places), 1
+	}
+
+	if parts := strings.Split(value, ","); len(parts) > 1 {
+		if places, ok := g.names[normalizePlaceName(parts[0])]; ok && partialMatchConfidence >= minConfidence {
+			return mostPopulous(places), partialMatchConfidence
+		}
+	}
+
+	// only names sharing the first letter are compared to keep lookups fast
+	target := []rune(name)
+	var best []*place
+	bestConfidence := 0.0
+	for _, candidate := range g.buckets[target[0]] {
+		runes := []rune(candidate)
+		if abs(len(runes)-len(target)) > maxEditDistance {
+			continue
+		}
+		distance := editDistance(target, runes)
+		confidence := 1 - float64(distance)/float64(max(len(runes), len(target)))
+		if confidence > bestConfidence {
+			best = g.names[candidate]
+			bestConfidence = confidence
+		} else if confidence == bestConfidence {
+			best = append(append([]*place{}, best...), g.names[candidate]...)
+		}
+	}
+	if best == nil || bestConfidence < minConfidence {
+		return nil, 0
+	}
+
+	return mostPopulous(best), bestConfidence
+}
+
+// normalizePlaceName lower cases the name, strips accents and collapses
+// punctuation and whitespace into single spaces.
+func normalizePlaceName(name string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), name)
+	if err != nil {
+		stripped = name
+	}
+
+	return strings.Join(strings.FieldsFunc(strings.ToLower(stripped), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func mostPopulous(places []*place) *place {
+	var best *place
+	for _, p := range places {
+		if best == nil || p.Population > best.Population {
+			best = p
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(min(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
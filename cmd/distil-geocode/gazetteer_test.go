@@ -0,0 +1,143 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// geonamesRow builds a GeoNames dump line from the columns used by the
+// gazetteer.
+func geonamesRow(name string, asciiName string, alternateNames string, lat string, lon string, country string, population string) string {
+	fields := make([]string, geonamesMinColumns+4)
+	fields[geonamesName] = name
+	fields[geonamesASCIIName] = asciiName
+	fields[geonamesAlternateNames] = alternateNames
+	fields[geonamesLatitude] = lat
+	fields[geonamesLongitude] = lon
+	fields[geonamesCountryCode] = country
+	fields[geonamesPopulation] = population
+	return strings.Join(fields, "\t")
+}
+
+func TestGazetteerMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gazetteer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "places.txt")
+	rows := []string{
+		geonamesRow("Paris", "Paris", "Paname,París", "48.85341", "2.3488", "FR", "2138551"),
+		geonamesRow("Paris", "Paris", "", "33.66094", "-95.55551", "US", "24782"),
+		geonamesRow("Montréal", "Montreal", "", "45.50884", "-73.58781", "CA", "1600000"),
+		geonamesRow("London", "London", "", "51.50853", "-0.12574", "GB", "8961989"),
+		"too\tfew\tcolumns",
+	}
+	err = ioutil.WriteFile(filename, []byte(strings.Join(rows, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadGazetteer(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.places) != 4 {
+		t.Fatalf("loaded %d places, expected 4", len(g.places))
+	}
+
+	tests := []struct {
+		name          string
+		value         string
+		minConfidence float64
+		country       string
+		confidence    float64
+	}{
+		{"exact", "London", 0.8, "GB", 1},
+		{"most populous", "Paris", 0.8, "FR", 1},
+		{"case and spacing", "  PARIS ", 0.8, "FR", 1},
+		{"ascii name", "montreal", 0.8, "CA", 1},
+		{"accents", "MONTRÉAL", 0.8, "CA", 1},
+		{"alternate name", "Paname", 0.8, "FR", 1},
+		{"alternate name accents", "PARÍS", 0.8, "FR", 1},
+		{"partial", "Paris, Texas", 0.8, "FR", partialMatchConfidence},
+		{"partial below minimum", "Paris, Texas", 0.95, "", 0},
+		{"fuzzy", "Londn", 0.8, "GB", 1 - 1.0/6},
+		{"fuzzy most populous", "Pariss", 0.8, "FR", 1 - 1.0/6},
+		{"fuzzy below minimum", "Londn", 0.9, "", 0},
+		{"too different", "Lyon", 0.6, "", 0},
+		{"unknown initial", "Berlin", 0, "", 0},
+		{"empty", "", 0, "", 0},
+		{"punctuation only", ", ,", 0, "", 0},
+	}
+	for _, test := range tests {
+		p, confidence := g.match(test.value, test.minConfidence)
+		if test.country == "" {
+			if p != nil || confidence != 0 {
+				t.Errorf("%s: match(%q) = %v %f, expected no match", test.name, test.value, p, confidence)
+			}
+			continue
+		}
+		if p == nil || p.Country != test.country || math.Abs(confidence-test.confidence) > 1e-9 {
+			t.Errorf("%s: match(%q) = %v %f, expected %s %f", test.name, test.value, p, confidence, test.country, test.confidence)
+		}
+	}
+}
+
+func TestNormalizePlaceName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Montréal", "montreal"},
+		{"São Paulo", "sao paulo"},
+		{"  St. John's ", "st john s"},
+		{"Köln-Ehrenfeld", "koln ehrenfeld"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if actual := normalizePlaceName(test.name); actual != test.expected {
+			t.Errorf("normalizePlaceName(%q) = %q, expected %q", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"", "", 0},
+		{"paris", "paris", 0},
+		{"paris", "", 5},
+		{"londn", "london", 1},
+		{"pariss", "paris", 1},
+		{"kitten", "sitting", 3},
+		{"montréal", "montreal", 1},
+	}
+	for _, test := range tests {
+		if actual := editDistance([]rune(test.a), []rune(test.b)); actual != test.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", test.a, test.b, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+	"github.com/uncharted-distil/distil/api/env"
+	"github.com/uncharted-distil/distil/api/serialization"
+	"github.com/uncharted-distil/distil/api/util"
+	log "github.com/unchartedsoftware/plog"
+)
+
+// unmatchedValue is a value that could not be found in the gazetteer.
+type unmatchedValue struct {
+	VariableKey string
+	Value       string
+	Count       int
+}
+
+// geocodeLocal geocodes the selected location variables of the dataset
+// against the gazetteer, appending latitude, longitude and match confidence variables for
+// each of them and writing the data and schema where GeocodeForwardDataset
+// does.
+func geocodeLocal(schemaFile string, gazetteerPath string, minConfidence float64, unmatchedReport string, filter *variables.Filter) (string, error) {
+	meta, err := loadGeocodeMetadata(schemaFile)
+	if err != nil {
+		return "", err
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	lines = lines[1:]
+
+	colsToGeocode, err := selectGeocodeVariables(mainDR.Variables, filter)
+	if err != nil {
+		return "", err
+	}
+	if len(colsToGeocode) == 0 {
+		log.Infof("no location variables found to geocode")
+		return schemaFile, nil
+	}
+
+	log.Infof("loading gazetteer %s", gazetteerPath)
+	g, err := loadGazetteer(gazetteerPath)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("loaded %d places", len(g.places))
+
+	unmatched := []*unmatchedValue{}
+	for _, col := range colsToGeocode {
+		latName, lonName := fmt.Sprintf("_lat_%s", col.Key), fmt.Sprintf("_lon_%s", col.Key)
+		confidenceName := fmt.Sprintf("_geoconf_%s", col.Key)
+		mainDR.Variables = append(mainDR.Variables,
+			model.NewVariable(len(mainDR.Variables), latName, "label", latName, latName, model.LatitudeType, model.LatitudeType,
+				fmt.Sprintf("latitude obtained from field %s", col.Key), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false),
+			model.NewVariable(len(mainDR.Variables)+1, lonName, "label", lonName, lonName, model.LongitudeType, model.LongitudeType,
+				fmt.Sprintf("longitude obtained from field %s", col.Key), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false),
+			model.NewVariable(len(mainDR.Variables)+2, confidenceName, "confidence", confidenceName, confidenceName, model.RealType, model.RealType,
+				fmt.Sprintf("geocoding match confidence of field %s", col.Key), []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false),
+		)
+
+		// values repeat a lot so each distinct value is only matched once
+		matches := map[string]*place{}
+		confidences := map[string]float64{}
+		misses := map[string]int{}
+		for i, line := range lines {
+			value := ""
+			if col.Index < len(line) {
+				value = strings.TrimSpace(line[col.Index])
+			}
+			if _, ok := matches[value]; !ok {
+				matches[value], confidences[value] = g.match(value, minConfidence)
+			}
+
+			p := matches[value]
+			if p == nil {
+				if value != "" {
+					misses[value]++
+				}
+				lines[i] = append(line, "", "", "0")
+				continue
+			}
+			lines[i] = append(line, p.Latitude, p.Longitude, strconv.FormatFloat(confidences[value], 'f', 4, 64))
+		}
+
+		log.Infof("geocoded field %s with %d unmatched values", col.Key, len(misses))
+		for value, count := range misses {
+			unmatched = append(unmatched, &unmatchedValue{
+				VariableKey: col.Key,
+				Value:       value,
+				Count:       count,
+			})
+		}
+	}
+
+	err = writeGeocodedDataset(schemaFile, meta, lines)
+	if err != nil {
+		return "", err
+	}
+
+	if unmatchedReport != "" {
+		err = writeUnmatchedReport(unmatchedReport, unmatched)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return schemaFile, nil
+}
+
+// loadGeocodeMetadata loads the metadata with the classified types when the
+// dataset has been classified, and the schema types otherwise.
+func loadGeocodeMetadata(schemaFile string) (*model.Metadata, error) {
+	config, err := env.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	classificationPath := path.Join(path.Dir(schemaFile), config.ClassificationOutputPath)
+	if util.FileExists(classificationPath) {
+		meta, err := metadata.LoadMetadataFromClassification(schemaFile, classificationPath, false, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load original schema file")
+		}
+		return meta, nil
+	}
+
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load original schema file")
+	}
+	return meta, nil
+}
+
+// writeGeocodedDataset writes the data and schema with the geocoded variables
+// in place of the original ones.
+func writeGeocodedDataset(schemaFile string, meta *model.Metadata, lines [][]string) error {
+	mainDR := meta.GetMainDataResource()
+	outputData := path.Join(path.Dir(schemaFile), compute.D3MDataFolder, compute.D3MLearningData)
+	output := [][]string{mainDR.GenerateHeader()}
+	output = append(output, lines...)
+	datasetStorage := serialization.GetStorage(outputData)
+	err := datasetStorage.WriteData(outputData, output)
+	if err != nil {
+		return errors.Wrap(err, "error writing feature output")
+	}
+	mainDR.ResPath = outputData
+
+	err = datasetStorage.WriteMetadata(schemaFile, meta, true, false)
+	if err != nil {
+		return errors.Wrap(err, "unable to store feature schema")
+	}
+
+	return nil
+}
+
+// selectGeocodeVariables picks the selected variables that hold locations.
+func selectGeocodeVariables(variables []*model.Variable, filter *variables.Filter) ([]*model.Variable, error) {
+	names := []string{}
+	for _, v := range variables {
+		names = append(names, v.Key, v.HeaderName)
+	}
+	err := filter.Validate(names)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []*model.Variable{}
+	for _, v := range variables {
+		if isLocationVariable(v) && filter.Selected(v.Key, v.HeaderName) {
+			selected = append(selected, v)
+		}
+	}
+	return selected, nil
+}
+
+func isLocationVariable(v *model.Variable) bool {
+	if isLocationType(v.Type) {
+		return true
+	}
+	for _, t := range v.SuggestedTypes {
+		if isLocationType(t.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLocationType(typ string) bool {
+	return typ == model.AddressType || typ == model.CityType || typ == model.CountryType ||
+		typ == model.PostalCodeType || typ == model.StateType || typ == model.TA2LocationType
+}
+
+// writeUnmatchedReport writes the values that could not be geocoded to a CSV
+// file, most frequent first.
+func writeUnmatchedReport(filename string, unmatched []*unmatchedValue) error {
+	sort.SliceStable(unmatched, func(i, j int) bool {
+		if unmatched[i].VariableKey != unmatched[j].VariableKey {
+			return unmatched[i].VariableKey < unmatched[j].VariableKey
+		}
+		if unmatched[i].Count != unmatched[j].Count {
+			return unmatched[i].Count > unmatched[j].Count
+		}
+		return unmatched[i].Value < unmatched[j].Value
+	})
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", filename)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"variable", "value", "count"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write unmatched values")
+	}
+	for _, u := range unmatched {
+		err = writer.Write([]string{u.VariableKey, u.Value, strconv.Itoa(u.Count)})
+		if err != nil {
+			return errors.Wrap(err, "unable to write unmatched values")
+		}
+	}
+	writer.Flush()
+
+	return errors.Wrap(writer.Error(), "unable to write unmatched values")
+}
@@ -25,6 +25,8 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -50,7 +52,7 @@ func main() {
 	app.Name = "distil-geocode"
 	app.Version = "0.1.0"
 	app.Usage = "Geocode D3M merged datasets"
-	app.UsageText = "distil-geocode --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-geocode [--endpoint=<url> | --gazetteer=<filepath> | --reverse --boundaries=<filepath>] [--variables=<names>] [--exclude-variables=<names>] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -77,14 +79,64 @@ func main() {
 			Value: "",
 			Usage: "The path to use as output for the geocoded data",
 		},
+		cli.StringFlag{
+			Name:  "gazetteer",
+			Value: "",
+			Usage: "A GeoNames style TSV of places to geocode against instead of a TA2, also used to find the nearest place when reverse geocoding",
+		},
+		cli.Float64Flag{
+			Name:  "min-confidence",
+			Value: 0.8,
+			Usage: "The confidence below which a fuzzy gazetteer match is rejected",
+		},
+		cli.StringFlag{
+			Name:  "unmatched-report",
+			Value: "",
+			Usage: "The CSV file listing the values not found in the gazetteer",
+		},
+		cli.StringFlag{
+			Name:  "variables",
+			Value: "",
+			Usage: "The comma separated location variables to geocode, defaulting to all of them",
+		},
+		cli.StringFlag{
+			Name:  "exclude-variables",
+			Value: "",
+			Usage: "The comma separated location variables to leave as they are",
+		},
+		cli.BoolFlag{
+			Name:  "reverse",
+			Usage: "Name the country, admin regions and nearest place of coordinates using the boundaries file",
+		},
+		cli.StringFlag{
+			Name:  "boundaries",
+			Value: "",
+			Usage: "A GeoJSON file of country and admin region boundaries used for reverse geocoding",
+		},
+		cli.StringFlag{
+			Name:  "latitude",
+			Value: "",
+			Usage: "The latitude variable to reverse geocode, defaulting to the first latitude variable",
+		},
+		cli.StringFlag{
+			Name:  "longitude",
+			Value: "",
+			Usage: "The longitude variable to reverse geocode, defaulting to the first longitude variable",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		if c.String("endpoint") == "" {
-			return cli.NewExitError("missing commandline flag `--endpoint`", 1)
+		if c.String("endpoint") == "" && c.String("gazetteer") == "" && !c.Bool("reverse") {
+			return cli.NewExitError("missing commandline flag `--endpoint` or `--gazetteer`", 1)
 		}
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
+		if (c.String("gazetteer") != "" || c.Bool("reverse")) && c.String("schema") == "" {
+			return cli.NewExitError("missing commandline flag `--schema`", 1)
+		}
+		if c.Bool("reverse") && c.String("boundaries") == "" {
+			return cli.NewExitError("missing commandline flag `--boundaries`", 1)
+		}
 
 		endpoint := c.String("endpoint")
 		dataset := c.String("dataset")
@@ -92,47 +144,75 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
+		filter := variables.NewFilter(splitAndTrim(c.String("variables")), splitAndTrim(c.String("exclude-variables")))
 
-		err = env.Initialize(&config)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		var geocodePath string
+		if c.Bool("reverse") {
+			geocodePath, err = geocodeReverse(schemaPath, c.String("boundaries"), c.String("gazetteer"), c.String("latitude"), c.String("longitude"))
+		} else if c.String("gazetteer") != "" {
+			geocodePath, err = geocodeLocal(schemaPath, c.String("gazetteer"), c.Float64("min-confidence"), c.String("unmatched-report"), filter)
+		} else {
+			geocodePath, err = geocodeRemote(endpoint, dataset, schemaPath, input, output, filter)
 		}
-		ingestConfig := task.NewConfig(config)
-
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		defer client.Close()
-		task.SetClient(client)
+		log.Infof("Geocoding for `%s` successful", working.SourcePath(geocodePath))
 
-		// geocode the file
-		geocodePath, err := task.GeocodeForwardDataset(schemaPath, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Geocoding for `%s` successful", geocodePath)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// geocodeRemote runs the geocoding pipeline through the TA2 and returns the
+// path of the schema it wrote.
+func geocodeRemote(endpoint string, dataset string, schemaPath string, input string, output string, filter *variables.Filter) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// geocode the file
+	if filter.Empty() {
+		return task.GeocodeForwardDataset(schemaPath, dataset, ingestConfig)
+	}
+	return geocodeSelected(schemaPath, dataset, ingestConfig, filter)
+}
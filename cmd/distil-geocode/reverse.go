@@ -0,0 +1,462 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil/api/serialization"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// earthRadiusKm is the mean radius used for great circle distances
+	earthRadiusKm = 6371.0
+	// placeCellSize is the size in degrees of the grid cells places are
+	// indexed by for nearest place lookups
+	placeCellSize = 1.0
+)
+
+var (
+	// boundary property names holding each admin level, covering both plain
+	// names and the GADM export names
+	countryProperties = []string{"country", "NAME_0", "ADMIN", "admin"}
+	admin1Properties  = []string{"admin1", "NAME_1"}
+	admin2Properties  = []string{"admin2", "NAME_2"}
+)
+
+// boundary is a region of a boundaries file with the names of its country and
+// admin regions. Deeper boundaries name more levels.
+type boundary struct {
+	Country  string
+	Admin1   string
+	Admin2   string
+	depth    int
+	polygons [][][][2]float64
+	minX     float64
+	minY     float64
+	maxX     float64
+	maxY     float64
+}
+
+// boundaries holds the regions of a boundaries file, deepest first.
+type boundaries struct {
+	regions []*boundary
+}
+
+type geoJSONFeatureCollection struct {
+	Features []*geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// loadBoundaries reads the Polygon and MultiPolygon features of a GeoJSON
+// feature collection, naming each by its country, admin1 and admin2
+// properties.
+func loadBoundaries(filename string) (*boundaries, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read boundaries %s", filename)
+	}
+	collection := &geoJSONFeatureCollection{}
+	err = json.Unmarshal(b, collection)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse boundaries %s", filename)
+	}
+
+	regions := []*boundary{}
+	for i, feature := range collection.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+		var polygons [][][][]float64
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var polygon [][][]float64
+			err = json.Unmarshal(feature.Geometry.Coordinates, &polygon)
+			polygons = [][][][]float64{polygon}
+		case "MultiPolygon":
+			err = json.Unmarshal(feature.Geometry.Coordinates, &polygons)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse geometry of feature %d", i)
+		}
+
+		region := &boundary{
+			Country: featureProperty(feature.Properties, countryProperties),
+			Admin1:  featureProperty(feature.Properties, admin1Properties),
+			Admin2:  featureProperty(feature.Properties, admin2Properties),
+			minX:    math.MaxFloat64,
+			minY:    math.MaxFloat64,
+			maxX:    -math.MaxFloat64,
+			maxY:    -math.MaxFloat64,
+		}
+		switch {
+		case region.Admin2 != "":
+			region.depth = 3
+		case region.Admin1 != "":
+			region.depth = 2
+		case region.Country != "":
+			region.depth = 1
+		default:
+			continue
+		}
+
+		for _, polygon := range polygons {
+			rings := make([][][2]float64, 0, len(polygon))
+			for _, ring := range polygon {
+				points := make([][2]float64, 0, len(ring))
+				for _, point := range ring {
+					if len(point) < 2 {
+						continue
+					}
+					points = append(points, [2]float64{point[0], point[1]})
+					region.minX = math.Min(region.minX, point[0])
+					region.minY = math.Min(region.minY, point[1])
+					region.maxX = math.Max(region.maxX, point[0])
+					region.maxY = math.Max(region.maxY, point[1])
+				}
+				rings = append(rings, points)
+			}
+			region.polygons = append(region.polygons, rings)
+		}
+		regions = append(regions, region)
+	}
+
+	// stable so boundaries of the same depth keep the file order
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].depth > regions[j].depth
+	})
+
+	return &boundaries{regions: regions}, nil
+}
+
+func featureProperty(properties map[string]interface{}, names []string) string {
+	for _, name := range names {
+		if value, ok := properties[name]; ok && value != nil {
+			if s := strings.TrimSpace(fmt.Sprintf("%v", value)); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// locate names the country and admin regions containing the point. Each level
+// comes from the deepest boundary containing the point that names it.
+func (b *boundaries) locate(lat float64, lon float64) (string, string, string) {
+	var country, admin1, admin2 string
+	for _, region := range b.regions {
+		if !region.contains(lon, lat) {
+			continue
+		}
+		if country == "" {
+			country = region.Country
+		}
+		if admin1 == "" {
+			admin1 = region.Admin1
+		}
+		if admin2 == "" {
+			admin2 = region.Admin2
+		}
+		if country != "" && admin1 != "" && admin2 != "" {
+			break
+		}
+	}
+	return country, admin1, admin2
+}
+
+func (r *boundary) contains(x float64, y float64) bool {
+	if x < r.minX || x > r.maxX || y < r.minY || y > r.maxY {
+		return false
+	}
+	for _, polygon := range r.polygons {
+		if len(polygon) == 0 || !ringContains(polygon[0], x, y) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, x, y) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains checks whether the point is in the ring by ray casting.
+func ringContains(ring [][2]float64, x float64, y float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// placeIndex buckets the gazetteer places into grid cells for nearest place
+// lookups.
+type placeIndex struct {
+	cells map[[2]int][]*indexedPlace
+}
+
+type indexedPlace struct {
+	place *place
+	lat   float64
+	lon   float64
+}
+
+func newPlaceIndex(g *gazetteer) *placeIndex {
+	index := &placeIndex{
+		cells: map[[2]int][]*indexedPlace{},
+	}
+	for _, p := range g.places {
+		lat, errLat := strconv.ParseFloat(p.Latitude, 64)
+		lon, errLon := strconv.ParseFloat(p.Longitude, 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+		cell := placeCell(lat, lon)
+		index.cells[cell] = append(index.cells[cell], &indexedPlace{place: p, lat: lat, lon: lon})
+	}
+	return index
+}
+
+func placeCell(lat float64, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / placeCellSize)), int(math.Floor(lon / placeCellSize))}
+}
+
+// nearest finds the place closest to the point, searching rings of cells
+// around it until no closer place can be found.
+func (index *placeIndex) nearest(lat float64, lon float64) *place {
+	if len(index.cells) == 0 {
+		return nil
+	}
+	center := placeCell(lat, lon)
+	maxRing := int(360 / placeCellSize)
+
+	var best *place
+	bestDistance := math.MaxFloat64
+	for ring := 0; ring <= maxRing; ring++ {
+		for dLat := -ring; dLat <= ring; dLat++ {
+			for dLon := -ring; dLon <= ring; dLon++ {
+				if abs(dLat) != ring && abs(dLon) != ring {
+					continue
+				}
+				for _, p := range index.cells[[2]int{center[0] + dLat, wrapCell(center[1] + dLon)}] {
+					if d := haversine(lat, lon, p.lat, p.lon); d < bestDistance {
+						best = p.place
+						bestDistance = d
+					}
+				}
+			}
+		}
+		if best != nil && bestDistance <= unsearchedDistance(lat, lon, center, ring) {
+			break
+		}
+	}
+	return best
+}
+
+// unsearchedDistance is the shortest distance from the point to any place
+// outside the cells within ring cells of its own. Places beyond the ring in
+// latitude are at least the latitude gap away. Places beyond it in longitude
+// are at least as far as the closest point of the meridian bounding the ring,
+// which gets closer towards the poles.
+func unsearchedDistance(lat float64, lon float64, center [2]int, ring int) float64 {
+	toRadians := math.Pi / 180
+	distance := math.MaxFloat64
+
+	south := float64(center[0]-ring) * placeCellSize
+	if south > -90 {
+		distance = math.Min(distance, (lat-south)*toRadians*earthRadiusKm)
+	}
+	north := float64(center[0]+ring+1) * placeCellSize
+	if north < 90 {
+		distance = math.Min(distance, (north-lat)*toRadians*earthRadiusKm)
+	}
+
+	if float64(2*ring+1)*placeCellSize < 360 {
+		west := lon - float64(center[1]-ring)*placeCellSize
+		east := float64(center[1]+ring+1)*placeCellSize - lon
+		gap := math.Min(math.Min(west, east), 90) * toRadians
+		distance = math.Min(distance, earthRadiusKm*math.Asin(math.Cos(lat*toRadians)*math.Sin(gap)))
+	}
+
+	return distance
+}
+
+// wrapCell wraps a longitude cell around the antimeridian.
+func wrapCell(cell int) int {
+	cells := int(360 / placeCellSize)
+	offset := int(180 / placeCellSize)
+	return ((cell+offset)%cells+cells)%cells - offset
+}
+
+// haversine is the great circle distance in kilometres between two points.
+func haversine(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	toRadians := math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLon := (lon2 - lon1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// reverseVariable is a variable added by reverse geocoding.
+type reverseVariable struct {
+	prefix      string
+	typ         string
+	description string
+}
+
+// geocodeReverse names the country, admin1 and admin2 regions of the points of
+// the coordinate variables using the boundaries file, along with the nearest
+// place when a gazetteer is given. The names are appended as new variables
+// and the data and schema written where GeocodeForwardDataset does.
+func geocodeReverse(schemaFile string, boundariesPath string, gazetteerPath string, latitude string, longitude string) (string, error) {
+	meta, err := loadGeocodeMetadata(schemaFile)
+	if err != nil {
+		return "", err
+	}
+	mainDR := meta.GetMainDataResource()
+
+	latVariable, err := findCoordinateVariable(mainDR.Variables, latitude, model.LatitudeType)
+	if err != nil {
+		return "", err
+	}
+	lonVariable, err := findCoordinateVariable(mainDR.Variables, longitude, model.LongitudeType)
+	if err != nil {
+		return "", err
+	}
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	lines = lines[1:]
+
+	log.Infof("loading boundaries %s", boundariesPath)
+	regions, err := loadBoundaries(boundariesPath)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("loaded %d boundaries", len(regions.regions))
+
+	var places *placeIndex
+	if gazetteerPath != "" {
+		log.Infof("loading gazetteer %s", gazetteerPath)
+		g, err := loadGazetteer(gazetteerPath)
+		if err != nil {
+			return "", err
+		}
+		places = newPlaceIndex(g)
+	}
+
+	suffix := fmt.Sprintf("%s_%s", latVariable.Key, lonVariable.Key)
+	added := []*reverseVariable{
+		{"_country", model.CountryType, "country"},
+		{"_admin1", model.StateType, "first level admin region"},
+		{"_admin2", model.CategoricalType, "second level admin region"},
+	}
+	if places != nil {
+		added = append(added, &reverseVariable{"_place", model.CityType, "nearest place"})
+	}
+	for _, a := range added {
+		name := fmt.Sprintf("%s_%s", a.prefix, suffix)
+		desc := fmt.Sprintf("%s obtained from fields %s and %s", a.description, latVariable.Key, lonVariable.Key)
+		mainDR.Variables = append(mainDR.Variables, model.NewVariable(len(mainDR.Variables), name, "label", name, name, a.typ, a.typ,
+			desc, []string{model.RoleAttribute}, model.VarDistilRoleMetadata, nil, mainDR.Variables, false))
+	}
+
+	located := 0
+	for i, line := range lines {
+		values := make([]string, len(added))
+		lat, errLat := parseCoordinate(line, latVariable.Index)
+		lon, errLon := parseCoordinate(line, lonVariable.Index)
+		if errLat == nil && errLon == nil {
+			values[0], values[1], values[2] = regions.locate(lat, lon)
+			if values[0] != "" {
+				located++
+			}
+			if places != nil {
+				if p := places.nearest(lat, lon); p != nil {
+					values[3] = p.Name
+				}
+			}
+		}
+		lines[i] = append(line, values...)
+	}
+	log.Infof("located %d of %d rows within the boundaries", located, len(lines))
+
+	err = writeGeocodedDataset(schemaFile, meta, lines)
+	if err != nil {
+		return "", err
+	}
+
+	return schemaFile, nil
+}
+
+func parseCoordinate(line []string, index int) (float64, error) {
+	if index >= len(line) {
+		return 0, errors.Errorf("missing coordinate")
+	}
+	return strconv.ParseFloat(strings.TrimSpace(line[index]), 64)
+}
+
+// findCoordinateVariable finds the variable by key or header name, or the
+// first variable of the coordinate type when no name is given.
+func findCoordinateVariable(variables []*model.Variable, name string, typ string) (*model.Variable, error) {
+	for _, v := range variables {
+		if name != "" && (v.Key == name || v.HeaderName == name) {
+			return v, nil
+		}
+		if name == "" && v.Type == typ {
+			return v, nil
+		}
+	}
+	if name == "" {
+		return nil, errors.Errorf("no %s variable found", typ)
+	}
+	return nil, errors.Errorf("variable '%s' not found", name)
+}
@@ -0,0 +1,77 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlaceIndexNearest(t *testing.T) {
+	g := &gazetteer{places: []*place{
+		{Name: "west", Latitude: "70.5", Longitude: "-0.9"},
+		{Name: "east", Latitude: "70", Longitude: "2.2"},
+		{Name: "equator", Latitude: "0", Longitude: "0"},
+		{Name: "dateline", Latitude: "-10", Longitude: "179.8"},
+		{Name: "unparsed", Latitude: "", Longitude: "1"},
+	}}
+	index := newPlaceIndex(g)
+
+	tests := []struct {
+		name     string
+		lat      float64
+		lon      float64
+		expected string
+	}{
+		// a degree of longitude is a third as long at 70 degrees, so the
+		// place two cells east is closer than the one a cell west
+		{"high latitude", 70, 0.9, "east"},
+		{"same cell", 0.5, 0.5, "equator"},
+		{"far away", 30, 10, "equator"},
+		{"across the antimeridian", -10, -179.9, "dateline"},
+	}
+	for _, test := range tests {
+		p := index.nearest(test.lat, test.lon)
+		if p == nil || p.Name != test.expected {
+			t.Errorf("%s: nearest(%f, %f) = %v, expected %s", test.name, test.lat, test.lon, p, test.expected)
+		}
+	}
+
+	if p := newPlaceIndex(&gazetteer{}).nearest(0, 0); p != nil {
+		t.Errorf("nearest in an empty index = %v, expected nil", p)
+	}
+}
+
+func TestUnsearchedDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		lat  float64
+		lon  float64
+		ring int
+		min  float64
+		max  float64
+	}{
+		{"equator", 0.5, 0.5, 0, 55, 56},
+		{"high latitude", 70.5, 0.5, 0, 18, 20},
+		{"whole globe", 0.5, 0.5, 360, math.MaxFloat64, math.MaxFloat64},
+	}
+	for _, test := range tests {
+		d := unsearchedDistance(test.lat, test.lon, placeCell(test.lat, test.lon), test.ring)
+		if d < test.min || d > test.max {
+			t.Errorf("%s: unsearchedDistance = %f, expected between %f and %f", test.name, d, test.min, test.max)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	arffNumeric = "numeric"
+	arffInteger = "integer"
+	arffReal    = "real"
+	arffString  = "string"
+	arffDate    = "date"
+	arffNominal = "nominal"
+
+	// arffDefaultDateFormat is the format of dates declared without one
+	arffDefaultDateFormat = "yyyy-MM-dd'T'HH:mm:ss"
+)
+
+// arffFile is the relation, attributes and rows of an ARFF file. Missing
+// values are read as empty strings.
+type arffFile struct {
+	Relation   string
+	Attributes []*arffAttribute
+	Rows       [][]string
+}
+
+// arffAttribute is an attribute declaration. Nominal attributes list their
+// values and date attributes keep their format, which is parsed into the Go
+// layouts used to read the dates and write them as ISO 8601.
+type arffAttribute struct {
+	Name   string
+	Type   string
+	Values []string
+	Format string
+	layout string
+	output string
+}
+
+// readARFF reads a dense or sparse ARFF file.
+func readARFF(filename string) (*arffFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open ARFF file")
+	}
+	defer file.Close()
+
+	arff := &arffFile{}
+	inData := false
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+
+		if inData {
+			row, err := arff.parseRow(line)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNumber)
+			}
+			arff.Rows = append(arff.Rows, row)
+			continue
+		}
+
+		keyword, rest := splitKeyword(line)
+		switch strings.ToLower(keyword) {
+		case "@relation":
+			arff.Relation = unquoteARFF(rest)
+		case "@attribute":
+			attribute, err := parseAttribute(rest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNumber)
+			}
+			arff.Attributes = append(arff.Attributes, attribute)
+		case "@data":
+			inData = true
+		default:
+			return nil, errors.Errorf("line %d: unexpected declaration '%s'", lineNumber, keyword)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read ARFF file")
+	}
+	if len(arff.Attributes) == 0 {
+		return nil, errors.Errorf("ARFF file %s declares no attributes", filename)
+	}
+	if !inData {
+		return nil, errors.Errorf("ARFF file %s has no @data section", filename)
+	}
+
+	return arff, nil
+}
+
+// parseAttribute parses the name and type following @attribute.
+func parseAttribute(declaration string) (*arffAttribute, error) {
+	name, rest := splitName(declaration)
+	if name == "" || rest == "" {
+		return nil, errors.Errorf("invalid attribute declaration '%s'", declaration)
+	}
+	attribute := &arffAttribute{Name: name}
+
+	if strings.HasPrefix(rest, "{") {
+		if !strings.HasSuffix(rest, "}") {
+			return nil, errors.Errorf("unterminated values of nominal attribute %s", name)
+		}
+		fields, err := splitARFF(rest[1 : len(rest)-1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid values of nominal attribute %s", name)
+		}
+		attribute.Type = arffNominal
+		for _, field := range fields {
+			attribute.Values = append(attribute.Values, unquoteARFF(field))
+		}
+		return attribute, nil
+	}
+
+	typ, format := splitKeyword(rest)
+	attribute.Type = strings.ToLower(typ)
+	switch attribute.Type {
+	case arffNumeric, arffInteger, arffReal, arffString:
+	case arffDate:
+		attribute.Format = unquoteARFF(format)
+		if attribute.Format == "" {
+			attribute.Format = arffDefaultDateFormat
+		}
+		var err error
+		attribute.layout, attribute.output, err = dateLayout(attribute.Format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid date format of attribute %s", name)
+		}
+	default:
+		return nil, errors.Errorf("attribute %s has unsupported type '%s'", name, typ)
+	}
+
+	return attribute, nil
+}
+
+// parseRow reads a data line, filling in the values a sparse line leaves out.
+func (a *arffFile) parseRow(line string) ([]string, error) {
+	if !strings.HasPrefix(line, "{") {
+		fields, err := splitARFF(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != len(a.Attributes) {
+			return nil, errors.Errorf("expected %d values but found %d", len(a.Attributes), len(fields))
+		}
+		row := make([]string, len(fields))
+		for i, field := range fields {
+			row[i], err = a.Attributes[i].value(field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return row, nil
+	}
+
+	if !strings.HasSuffix(line, "}") {
+		return nil, errors.New("unterminated sparse row")
+	}
+	// values left out of a sparse row are zero, which is the first value of
+	// a nominal attribute
+	row := make([]string, len(a.Attributes))
+	for i, attribute := range a.Attributes {
+		switch attribute.Type {
+		case arffNominal:
+			if len(attribute.Values) > 0 {
+				row[i] = attribute.Values[0]
+			}
+		case arffNumeric, arffInteger, arffReal:
+			row[i] = "0"
+		}
+	}
+	inner := strings.TrimSpace(line[1 : len(line)-1])
+	if inner == "" {
+		// every value is left out
+		return row, nil
+	}
+	fields, err := splitARFF(inner)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		index, value := splitKeyword(field)
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(a.Attributes) {
+			return nil, errors.Errorf("invalid sparse index '%s'", index)
+		}
+		row[i], err = a.Attributes[i].value(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return row, nil
+}
+
+// value reads the value of a field, writing dates as ISO 8601.
+func (a *arffAttribute) value(field string) (string, error) {
+	value := unquoteARFF(field)
+	if a.Type != arffDate || value == "" {
+		return value, nil
+	}
+	t, err := time.Parse(a.layout, value)
+	if err != nil {
+		return "", errors.Errorf("value '%s' of attribute %s does not match the date format '%s'", value, a.Name, a.Format)
+	}
+	return t.Format(a.output), nil
+}
+
+// dateLayout converts a Java SimpleDateFormat pattern, which ARFF uses for
+// date formats, into the Go layout reading the dates and the ISO 8601 layout
+// writing them. Dates are written without a time when the format has none,
+// and with the offset when it has a time zone.
+func dateLayout(format string) (string, string, error) {
+	var layout strings.Builder
+	hasTime := false
+	hasZone := false
+	for i := 0; i < len(format); {
+		c := format[i]
+		if c == '\'' {
+			// quoted text is literal and '' is a quote
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return "", "", errors.Errorf("unterminated quote in '%s'", format)
+			}
+			if end == 0 {
+				layout.WriteByte('\'')
+			}
+			layout.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			layout.WriteByte(c)
+			i++
+			continue
+		}
+
+		n := 1
+		for i+n < len(format) && format[i+n] == c {
+			n++
+		}
+		i += n
+		switch c {
+		case 'y':
+			if n == 2 {
+				layout.WriteString("06")
+			} else {
+				layout.WriteString("2006")
+			}
+		case 'M':
+			layout.WriteString(pickLayout(n, "1", "01", "Jan", "January"))
+		case 'd':
+			layout.WriteString(pickLayout(n, "2", "02", "02", "02"))
+		case 'E':
+			layout.WriteString(pickLayout(n, "Mon", "Mon", "Mon", "Monday"))
+		case 'H':
+			layout.WriteString("15")
+			hasTime = true
+		case 'h':
+			layout.WriteString(pickLayout(n, "3", "03", "03", "03"))
+			hasTime = true
+		case 'm':
+			layout.WriteString(pickLayout(n, "4", "04", "04", "04"))
+			hasTime = true
+		case 's':
+			layout.WriteString(pickLayout(n, "5", "05", "05", "05"))
+			hasTime = true
+		case 'S':
+			// Go only reads fractional seconds following a period
+			if !strings.HasSuffix(layout.String(), ".") {
+				return "", "", errors.Errorf("milliseconds must follow a period in '%s'", format)
+			}
+			layout.WriteString(strings.Repeat("0", n))
+		case 'a':
+			layout.WriteString("PM")
+		case 'z':
+			layout.WriteString("MST")
+			hasZone = true
+		case 'Z':
+			layout.WriteString("-0700")
+			hasZone = true
+		case 'X':
+			layout.WriteString(pickLayout(n, "Z07", "Z0700", "Z07:00", "Z07:00"))
+			hasZone = true
+		default:
+			return "", "", errors.Errorf("unsupported pattern letter '%c' in '%s'", c, format)
+		}
+	}
+
+	switch {
+	case hasZone:
+		return layout.String(), time.RFC3339, nil
+	case hasTime:
+		return layout.String(), "2006-01-02T15:04:05", nil
+	default:
+		return layout.String(), "2006-01-02", nil
+	}
+}
+
+// pickLayout picks the layout of a pattern letter repeated n times.
+func pickLayout(n int, one string, two string, three string, four string) string {
+	switch n {
+	case 1:
+		return one
+	case 2:
+		return two
+	case 3:
+		return three
+	default:
+		return four
+	}
+}
+
+// splitKeyword splits off the first whitespace separated word.
+func splitKeyword(s string) (string, string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// splitName splits off a possibly quoted name.
+func splitName(s string) (string, string) {
+	if s == "" || (s[0] != '\'' && s[0] != '"') {
+		return splitKeyword(s)
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == s[0] {
+			return unquoteARFF(s[:i+1]), strings.TrimSpace(s[i+1:])
+		}
+	}
+	return "", ""
+}
+
+// splitARFF splits a comma separated line, keeping the quotes of quoted
+// fields so missing values can be told apart from quoted question marks.
+func splitARFF(line string) ([]string, error) {
+	fields := []string{}
+	var quote byte
+	start := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote == 0 && c == ',':
+			fields = append(fields, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quoted value")
+	}
+	fields = append(fields, strings.TrimSpace(line[start:]))
+
+	return fields, nil
+}
+
+// unquoteARFF returns the value of a field, which is empty for a missing
+// value.
+func unquoteARFF(field string) string {
+	field = strings.TrimSpace(field)
+	if field == "?" {
+		return ""
+	}
+	if len(field) < 2 || (field[0] != '\'' && field[0] != '"') || field[len(field)-1] != field[0] {
+		return field
+	}
+
+	var value strings.Builder
+	inner := field[1 : len(field)-1]
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+			switch inner[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				value.WriteByte(inner[i])
+			}
+			continue
+		}
+		value.WriteByte(inner[i])
+	}
+
+	return value.String()
+}
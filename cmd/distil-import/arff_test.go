@@ -0,0 +1,205 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSplitARFF(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		valid    bool
+	}{
+		{"1,2,3", []string{"1", "2", "3"}, true},
+		{" 1 , 2 ", []string{"1", "2"}, true},
+		{"'a,b',c", []string{"'a,b'", "c"}, true},
+		{`"a,b",c`, []string{`"a,b"`, "c"}, true},
+		{`'it\'s',x`, []string{`'it\'s'`, "x"}, true},
+		{"?,'?'", []string{"?", "'?'"}, true},
+		{"a,,b", []string{"a", "", "b"}, true},
+		{"'open,x", nil, false},
+	}
+	for _, test := range tests {
+		actual, err := splitARFF(test.line)
+		if (err == nil) != test.valid || (test.valid && !reflect.DeepEqual(actual, test.expected)) {
+			t.Errorf("splitARFF(%q) = %q, %v, expected %q", test.line, actual, err, test.expected)
+		}
+	}
+}
+
+func TestUnquoteARFF(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+	}{
+		{"abc", "abc"},
+		{"?", ""},
+		{" ? ", ""},
+		{"'?'", "?"},
+		{`"?"`, "?"},
+		{"'a b'", "a b"},
+		{`'it\'s'`, "it's"},
+		{`'tab\there'`, "tab\there"},
+		{`'new\nline'`, "new\nline"},
+		{"'mismatched\"", "'mismatched\""},
+		{"'", "'"},
+	}
+	for _, test := range tests {
+		if actual := unquoteARFF(test.field); actual != test.expected {
+			t.Errorf("unquoteARFF(%q) = %q, expected %q", test.field, actual, test.expected)
+		}
+	}
+}
+
+func TestParseAttribute(t *testing.T) {
+	tests := []struct {
+		declaration string
+		expected    *arffAttribute
+		valid       bool
+	}{
+		{"width NUMERIC", &arffAttribute{Name: "width", Type: arffNumeric}, true},
+		{"'petal width' real", &arffAttribute{Name: "petal width", Type: arffReal}, true},
+		{"class {a, 'b c', ?}", &arffAttribute{Name: "class", Type: arffNominal, Values: []string{"a", "b c", ""}}, true},
+		{"when date 'yyyy-MM-dd'", &arffAttribute{Name: "when", Type: arffDate, Format: "yyyy-MM-dd", layout: "2006-01-02", output: "2006-01-02"}, true},
+		{"when date", &arffAttribute{Name: "when", Type: arffDate, Format: arffDefaultDateFormat,
+			layout: "2006-01-02T15:04:05", output: "2006-01-02T15:04:05"}, true},
+		{"name string", &arffAttribute{Name: "name", Type: arffString}, true},
+		{"class {a, b", nil, false},
+		{"when date 'yyyy-QQ'", nil, false},
+		{"points relational", nil, false},
+		{"lonely", nil, false},
+	}
+	for _, test := range tests {
+		actual, err := parseAttribute(test.declaration)
+		if (err == nil) != test.valid || (test.valid && !reflect.DeepEqual(actual, test.expected)) {
+			t.Errorf("parseAttribute(%q) = %+v, %v, expected %+v", test.declaration, actual, err, test.expected)
+		}
+	}
+}
+
+func TestParseRow(t *testing.T) {
+	arff := &arffFile{Attributes: []*arffAttribute{
+		{Name: "n", Type: arffNumeric},
+		{Name: "class", Type: arffNominal, Values: []string{"no", "yes"}},
+		{Name: "text", Type: arffString},
+		{Name: "when", Type: arffDate, Format: "dd/MM/yyyy", layout: "02/01/2006", output: "2006-01-02"},
+	}}
+	tests := []struct {
+		name     string
+		line     string
+		expected []string
+		valid    bool
+	}{
+		{"dense", "1.5,yes,'a, b',24/12/2020", []string{"1.5", "yes", "a, b", "2020-12-24"}, true},
+		{"missing values", "?,?,?,?", []string{"", "", "", ""}, true},
+		{"quoted question mark", "1,no,'?',?", []string{"1", "no", "?", ""}, true},
+		{"sparse", "{0 2, 2 'x y'}", []string{"2", "no", "x y", ""}, true},
+		{"sparse defaults", "{}", []string{"0", "no", "", ""}, true},
+		{"sparse missing", "{1 ?, 3 01/02/2003}", []string{"0", "", "", "2003-02-01"}, true},
+		{"too few values", "1,yes", nil, false},
+		{"bad sparse index", "{4 1}", nil, false},
+		{"unterminated sparse", "{0 1", nil, false},
+		{"bad date", "1,yes,x,2020-12-24", nil, false},
+	}
+	for _, test := range tests {
+		actual, err := arff.parseRow(test.line)
+		if (err == nil) != test.valid || (test.valid && !reflect.DeepEqual(actual, test.expected)) {
+			t.Errorf("%s: parseRow(%q) = %q, %v, expected %q", test.name, test.line, actual, err, test.expected)
+		}
+	}
+}
+
+func TestDateLayout(t *testing.T) {
+	tests := []struct {
+		format   string
+		value    string
+		expected string
+	}{
+		{"yyyy-MM-dd", "2020-03-05", "2020-03-05"},
+		{"yyyy-MM-dd'T'HH:mm:ss", "2020-03-05T14:07:09", "2020-03-05T14:07:09"},
+		{"yyyy-MM-dd HH:mm:ss.SSS", "2020-03-05 14:07:09.250", "2020-03-05T14:07:09"},
+		{"d/M/yy", "5/3/20", "2020-03-05"},
+		{"dd MMM yyyy", "05 Mar 2020", "2020-03-05"},
+		{"EEEE, MMMM d, yyyy", "Thursday, March 5, 2020", "2020-03-05"},
+		{"MM/dd/yyyy hh:mm a", "03/05/2020 02:07 PM", "2020-03-05T14:07:00"},
+		{"yyyy-MM-dd'T'HH:mm:ssXXX", "2020-03-05T14:07:09+01:00", "2020-03-05T14:07:09+01:00"},
+		{"yyyy-MM-dd HH:mm Z", "2020-03-05 14:07 -0500", "2020-03-05T14:07:00-05:00"},
+		{"yyyy.MM.dd 'at' HH''mm", "2020.03.05 at 14'07", "2020-03-05T14:07:00"},
+	}
+	for _, test := range tests {
+		attribute := &arffAttribute{Name: "when", Type: arffDate, Format: test.format}
+		var err error
+		attribute.layout, attribute.output, err = dateLayout(test.format)
+		if err != nil {
+			t.Errorf("dateLayout(%q) returned %v", test.format, err)
+			continue
+		}
+		actual, err := attribute.value(test.value)
+		if err != nil || actual != test.expected {
+			t.Errorf("reading %q as %q = %q, %v, expected %q", test.value, test.format, actual, err, test.expected)
+		}
+	}
+
+	for _, format := range []string{"yyyy-QQ", "HH:mm:ssSSS", "'open"} {
+		if _, _, err := dateLayout(format); err == nil {
+			t.Errorf("dateLayout(%q) accepted an unsupported format", format)
+		}
+	}
+}
+
+func TestReadARFF(t *testing.T) {
+	folder, err := ioutil.TempDir("", "arff-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	tests := []struct {
+		name     string
+		content  string
+		relation string
+		rows     int
+		valid    bool
+	}{
+		{"dense", "% comment\n@RELATION 'test data'\n\n@ATTRIBUTE a NUMERIC\n@ATTRIBUTE b {x,y}\n@DATA\n1,x\n% comment\n2,y\n", "test data", 2, true},
+		{"sparse", "@relation s\n@attribute a numeric\n@attribute b numeric\n@data\n{1 5}\n{}\n", "s", 2, true},
+		{"no attributes", "@relation s\n@data\n", "", 0, false},
+		{"no data", "@relation s\n@attribute a numeric\n", "", 0, false},
+		{"unknown declaration", "@relation s\n@attrib a numeric\n@data\n", "", 0, false},
+		{"bad row", "@relation s\n@attribute a numeric\n@data\n1,2\n", "", 0, false},
+	}
+	for _, test := range tests {
+		filename := path.Join(folder, test.name+".arff")
+		err := ioutil.WriteFile(filename, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arff, err := readARFF(filename)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: readARFF returned %v", test.name, err)
+			continue
+		}
+		if test.valid && (arff.Relation != test.relation || len(arff.Rows) != test.rows) {
+			t.Errorf("%s: read relation %q with %d rows, expected %q with %d", test.name, arff.Relation, len(arff.Rows), test.relation, test.rows)
+		}
+	}
+}
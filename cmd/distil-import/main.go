@@ -0,0 +1,39 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/urfave/cli"
+)
+
+func main() {
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	app := cli.NewApp()
+	app.Name = "distil-import"
+	app.Version = "0.1.0"
+	app.Usage = "Import datasets from other formats as D3M dataset folders"
+	app.UsageText = "distil-import <command> [command options]"
+	app.Commands = []cli.Command{
+		openMLCommand(),
+	}
+	// run app
+	app.Run(os.Args)
+}
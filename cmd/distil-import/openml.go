@@ -0,0 +1,446 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	api "github.com/uncharted-distil/distil/api/compute"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+const (
+	// openMLPrefix starts the ID of every dataset imported from OpenML
+	openMLPrefix = "o_"
+	// roleSuggestedTarget marks the default target in the dataset schema
+	roleSuggestedTarget = "suggestedTarget"
+
+	problemVersion       = "2.0"
+	problemSchemaVersion = "4.0.0"
+)
+
+// openMLDescription is the part of an OpenML dataset description used by the
+// import, as returned by the data/<id> endpoint of the OpenML API.
+type openMLDescription struct {
+	ID                     interface{} `json:"id"`
+	Name                   string      `json:"name"`
+	Version                interface{} `json:"version"`
+	Description            string      `json:"description"`
+	Licence                string      `json:"licence"`
+	Citation               string      `json:"citation"`
+	URL                    string      `json:"url"`
+	OriginalDataURL        string      `json:"original_data_url"`
+	DefaultTargetAttribute openMLList  `json:"default_target_attribute"`
+	RowIDAttribute         openMLList  `json:"row_id_attribute"`
+	IgnoreAttribute        openMLList  `json:"ignore_attribute"`
+}
+
+// openMLList is an attribute list, which OpenML gives either as a comma
+// separated string or as an array.
+type openMLList []string
+
+func (l *openMLList) UnmarshalJSON(b []byte) error {
+	var values []string
+	err := json.Unmarshal(b, &values)
+	if err != nil {
+		var value string
+		err = json.Unmarshal(b, &value)
+		if err != nil {
+			return err
+		}
+		values = []string{value}
+	}
+
+	*l = openMLList{}
+	for _, value := range values {
+		*l = append(*l, splitAndTrim(value)...)
+	}
+	return nil
+}
+
+func openMLCommand() cli.Command {
+	return cli.Command{
+		Name:      "openml",
+		Usage:     "Import an OpenML dataset from its ARFF file and JSON description",
+		UsageText: "distil-import openml --arff=<filepath> --description=<filepath> --output=<folder> [--id=<id>] [--target=<names>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "arff",
+				Value: "",
+				Usage: "The ARFF file of the dataset",
+			},
+			cli.StringFlag{
+				Name:  "description",
+				Value: "",
+				Usage: "The OpenML JSON description of the dataset",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Value: "",
+				Usage: "The folder to write the dataset and problem folders to",
+			},
+			cli.StringFlag{
+				Name:  "id",
+				Value: "",
+				Usage: "The dataset ID, defaulting to o_<OpenML id>",
+			},
+			cli.StringFlag{
+				Name:  "target",
+				Value: "",
+				Usage: "Comma separated target attributes, defaulting to the default target of the description",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("arff") == "" {
+				return cli.NewExitError("missing commandline flag `--arff`", 1)
+			}
+			if c.String("description") == "" {
+				return cli.NewExitError("missing commandline flag `--description`", 1)
+			}
+			if c.String("output") == "" {
+				return cli.NewExitError("missing commandline flag `--output`", 1)
+			}
+
+			description, err := loadOpenMLDescription(filepath.Clean(c.String("description")))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			targets := []string(description.DefaultTargetAttribute)
+			if c.String("target") != "" {
+				targets = splitAndTrim(c.String("target"))
+			}
+
+			schemaPath, problemPath, err := importOpenML(filepath.Clean(c.String("arff")), description,
+				filepath.Clean(c.String("output")), c.String("id"), targets)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("Imported dataset written to %s", schemaPath)
+			if problemPath != "" {
+				log.Infof("Problem written to %s", problemPath)
+			}
+
+			return nil
+		},
+	}
+}
+
+// loadOpenMLDescription reads an OpenML dataset description, either as
+// returned by the API or without the data_set_description wrapper.
+func loadOpenMLDescription(filename string) (*openMLDescription, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read OpenML description")
+	}
+
+	wrapper := struct {
+		Description *openMLDescription `json:"data_set_description"`
+	}{}
+	err = json.Unmarshal(b, &wrapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse OpenML description")
+	}
+	if wrapper.Description != nil {
+		return wrapper.Description, nil
+	}
+
+	description := &openMLDescription{}
+	err = json.Unmarshal(b, description)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse OpenML description")
+	}
+	return description, nil
+}
+
+// importOpenML writes the ARFF data as a D3M dataset in <output>/<id>_dataset
+// and, when there are targets, a problem predicting them in
+// <output>/<id>_problem. The row id and ignored attributes of the description
+// are left out and a d3mIndex numbers the rows instead.
+func importOpenML(arffPath string, description *openMLDescription, outputFolder string, datasetID string,
+	targets []string) (string, string, error) {
+	arff, err := readARFF(arffPath)
+	if err != nil {
+		return "", "", err
+	}
+	log.Infof("read %d rows and %d attributes of relation %s from %s", len(arff.Rows), len(arff.Attributes), arff.Relation, arffPath)
+
+	name := description.Name
+	if name == "" {
+		name = arff.Relation
+	}
+	if datasetID == "" {
+		openMLID := openMLValue(description.ID)
+		if openMLID == "" {
+			openMLID = model.NormalizeDatasetID(name)
+		}
+		datasetID = openMLPrefix + openMLID
+	}
+
+	dropped := map[string]bool{}
+	for _, attribute := range append(description.RowIDAttribute, description.IgnoreAttribute...) {
+		dropped[attribute] = true
+	}
+	isTarget := map[string]bool{}
+	for _, target := range targets {
+		if dropped[target] {
+			return "", "", errors.Errorf("target %s is a row id or ignored attribute", target)
+		}
+		isTarget[target] = true
+	}
+
+	// the d3mIndex goes first, followed by the attributes that are kept
+	dr := model.NewDataResource(compute.DefaultResourceID, model.ResTypeTable, map[string][]string{compute.D3MResourceFormat: {"csv"}})
+	dr.Variables = append(dr.Variables, model.NewVariable(0, model.D3MIndexFieldName, model.D3MIndexFieldName, model.D3MIndexFieldName,
+		model.D3MIndexFieldName, model.IntegerType, model.IntegerType, "", []string{model.RoleIndex}, model.VarDistilRoleIndex,
+		nil, dr.Variables, false))
+	kept := []int{}
+	targetVariables := []*model.Variable{}
+	targetAttributes := []*arffAttribute{}
+	for i, attribute := range arff.Attributes {
+		if dropped[attribute.Name] {
+			log.Infof("leaving out attribute %s", attribute.Name)
+			continue
+		}
+		if attribute.Name == model.D3MIndexFieldName {
+			return "", "", errors.Errorf("attribute %s clashes with the added index", attribute.Name)
+		}
+		role := model.RoleAttribute
+		if isTarget[attribute.Name] {
+			role = roleSuggestedTarget
+		}
+		typ := mapARFFType(attribute.Type)
+		v := model.NewVariable(len(dr.Variables), attribute.Name, attribute.Name, attribute.Name, attribute.Name, typ, typ,
+			"", []string{role}, model.VarDistilRoleData, nil, dr.Variables, false)
+		dr.Variables = append(dr.Variables, v)
+		kept = append(kept, i)
+		if isTarget[attribute.Name] {
+			targetVariables = append(targetVariables, v)
+			targetAttributes = append(targetAttributes, attribute)
+		}
+	}
+	if len(targetVariables) != len(targets) {
+		return "", "", errors.Errorf("targets %v are not all attributes of the ARFF file", targets)
+	}
+
+	lines := make([][]string, 0, len(arff.Rows)+1)
+	header := make([]string, len(dr.Variables))
+	for i, v := range dr.Variables {
+		header[i] = v.HeaderName
+	}
+	lines = append(lines, header)
+	for i, row := range arff.Rows {
+		line := make([]string, 0, len(kept)+1)
+		line = append(line, strconv.Itoa(i))
+		for _, j := range kept {
+			line = append(line, row[j])
+		}
+		lines = append(lines, line)
+	}
+
+	datasetFolder := path.Join(outputFolder, datasetID+"_dataset")
+	dr.ResPath = path.Join(compute.D3MDataFolder, compute.D3MLearningData)
+	csvStorage := serialization.GetCSVStorage()
+	err = csvStorage.WriteData(path.Join(datasetFolder, dr.ResPath), lines)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to write imported data")
+	}
+
+	meta := model.NewMetadata(datasetID, name, description.Description, model.NormalizeDatasetID(datasetID))
+	meta.DataResources = []*model.DataResource{dr}
+	schemaPath := path.Join(datasetFolder, compute.D3MDataSchema)
+	err = csvStorage.WriteMetadata(schemaPath, meta, false, false)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to store imported schema")
+	}
+	err = writeOpenMLAbout(schemaPath, description)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(targetVariables) == 0 {
+		log.Warnf("dataset %s has no default target so no problem was written", datasetID)
+		return schemaPath, "", nil
+	}
+	problemPath := path.Join(outputFolder, datasetID+"_problem", api.D3MProblem)
+	err = writeOpenMLProblem(problemPath, datasetID, name, targetVariables, targetAttributes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return schemaPath, problemPath, nil
+}
+
+// mapARFFType maps an ARFF attribute type to a distil type.
+func mapARFFType(typ string) string {
+	switch typ {
+	case arffNominal:
+		return model.CategoricalType
+	case arffInteger:
+		return model.IntegerType
+	case arffNumeric, arffReal:
+		return model.RealType
+	case arffDate:
+		return model.DateTimeType
+	default:
+		return model.StringType
+	}
+}
+
+// writeOpenMLAbout adds the license, citation, source and version of the
+// description to the schema, which the schema writer has no fields for.
+func writeOpenMLAbout(schemaPath string, description *openMLDescription) error {
+	b, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to read imported schema")
+	}
+	schema := map[string]interface{}{}
+	err = json.Unmarshal(b, &schema)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse imported schema")
+	}
+
+	about, ok := schema["about"].(map[string]interface{})
+	if !ok {
+		return errors.New("imported schema has no about section")
+	}
+	about["source"] = "OpenML"
+	if description.Licence != "" {
+		about["license"] = description.Licence
+	}
+	if description.Citation != "" {
+		about["citation"] = description.Citation
+	}
+	sourceURI := description.OriginalDataURL
+	if sourceURI == "" {
+		sourceURI = description.URL
+	}
+	if sourceURI != "" {
+		about["sourceURI"] = sourceURI
+	}
+	if version := openMLValue(description.Version); version != "" {
+		about["datasetVersion"] = version
+	}
+
+	b, err = json.MarshalIndent(schema, "", "	")
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal imported schema")
+	}
+	err = ioutil.WriteFile(schemaPath, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to write imported schema")
+	}
+
+	return nil
+}
+
+// writeOpenMLProblem writes a problem predicting the targets, picking the
+// task from the type of the first target.
+func writeOpenMLProblem(problemPath string, datasetID string, name string, targets []*model.Variable,
+	attributes []*arffAttribute) error {
+	taskKeywords := api.DefaultTaskType(targets[0].Type, "")
+	if attributes[0].Type == arffNominal && len(attributes[0].Values) == 2 {
+		taskKeywords = []string{compute.ClassificationTask, compute.BinaryTask}
+	}
+	metrics := []*api.ProblemPersistPerformanceMetric{}
+	if defaults := api.DefaultMetrics(taskKeywords); len(defaults) > 0 {
+		metrics = append(metrics, &api.ProblemPersistPerformanceMetric{Metric: defaults[0]})
+	}
+
+	problemTargets := make([]*api.ProblemPersistTarget, len(targets))
+	targetNames := make([]string, len(targets))
+	for i, v := range targets {
+		problemTargets[i] = &api.ProblemPersistTarget{
+			TargetIndex: i,
+			ResID:       compute.DefaultResourceID,
+			ColIndex:    v.Index,
+			ColName:     v.HeaderName,
+		}
+		targetNames[i] = v.HeaderName
+	}
+
+	problem := &api.ProblemPersist{
+		About: &api.ProblemPersistAbout{
+			ProblemID:            datasetID + "_problem",
+			ProblemName:          fmt.Sprintf("%s problem", name),
+			ProblemDescription:   fmt.Sprintf("Predict %s from the OpenML dataset %s.", strings.Join(targetNames, ", "), name),
+			TaskKeywords:         taskKeywords,
+			ProblemVersion:       problemVersion,
+			ProblemSchemaVersion: problemSchemaVersion,
+		},
+		Inputs: &api.ProblemPersistInput{
+			Data: []*api.ProblemPersistData{{
+				DatasetID: datasetID,
+				Targets:   problemTargets,
+			}},
+			PerformanceMetrics: metrics,
+		},
+	}
+
+	b, err := json.MarshalIndent(problem, "", "	")
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal problem")
+	}
+	err = os.MkdirAll(path.Dir(problemPath), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create problem directory")
+	}
+	err = ioutil.WriteFile(problemPath, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to write problem")
+	}
+
+	return nil
+}
+
+// openMLValue formats an ID or version, which OpenML gives as either a
+// string or a number.
+func openMLValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func splitAndTrim(arg string) []string {
+	var res []string
+	if arg == "" {
+		return res
+	}
+	split := strings.Split(arg, ",")
+	for _, str := range split {
+		res = append(res, strings.TrimSpace(str))
+	}
+	return res
+}
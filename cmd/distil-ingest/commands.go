@@ -0,0 +1,148 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	elastic "github.com/uncharted-distil/distil/api/model/storage/elastic"
+	pg "github.com/uncharted-distil/distil/api/model/storage/postgres"
+	"github.com/uncharted-distil/distil/api/postgres"
+	log "github.com/unchartedsoftware/plog"
+)
+
+// elasticFlags are the Elasticsearch connection flags shared by the
+// subcommands that work against already ingested datasets.
+func elasticFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "es-endpoint",
+			Value: "",
+			Usage: "The Elasticsearch endpoint",
+		},
+		cli.StringFlag{
+			Name:  "es-metadata-index",
+			Value: metadataIndexName,
+			Usage: "The Elasticsearch index holding the dataset metadata",
+		},
+		cli.StringFlag{
+			Name:  "es-model-index",
+			Value: modelIndexName,
+			Usage: "The Elasticsearch index holding the models",
+		},
+	}
+}
+
+// storageFlags extends the Elasticsearch flags with the postgres connection
+// flags.
+func storageFlags() []cli.Flag {
+	return append(elasticFlags(),
+		cli.StringFlag{
+			Name:  "database",
+			Value: "",
+			Usage: "The postgres database to use",
+		},
+		cli.StringFlag{
+			Name:  "db-host",
+			Value: "localhost",
+			Usage: "The postgres database hostname - defaults to localhost",
+		},
+		cli.IntFlag{
+			Name:  "db-port",
+			Value: 5432,
+			Usage: "The postgres database port - defaults to 5432",
+		},
+		cli.StringFlag{
+			Name:  "db-user",
+			Value: "",
+			Usage: "The database user to use.",
+		},
+		cli.StringFlag{
+			Name:  "db-password",
+			Value: "",
+			Usage: "The database password to use for authentication.",
+		},
+	)
+}
+
+// loadElasticConfig builds the config from the Elasticsearch flags, requiring
+// the endpoint to be set.
+func loadElasticConfig(c *cli.Context) (*env.Config, error) {
+	if c.String("es-endpoint") == "" {
+		return nil, cli.NewExitError("missing commandline flag `--es-endpoint`", 1)
+	}
+
+	config, err := env.LoadConfig()
+	if err != nil {
+		log.Errorf("%v", err)
+		return nil, cli.NewExitError(errors.Cause(err), 2)
+	}
+	config.ElasticEndpoint = c.String("es-endpoint")
+	config.ESDatasetsIndex = c.String("es-metadata-index")
+	config.ESModelsIndex = c.String("es-model-index")
+
+	return &config, nil
+}
+
+// loadStorageConfig builds the config from the storage flags, requiring both
+// the Elasticsearch endpoint and the postgres database to be set.
+func loadStorageConfig(c *cli.Context) (*env.Config, error) {
+	if c.String("database") == "" {
+		return nil, cli.NewExitError("missing commandline flag `--database`", 1)
+	}
+	config, err := loadElasticConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	config.PostgresDatabase = c.String("database")
+	config.PostgresUser = c.String("db-user")
+	config.PostgresPassword = c.String("db-password")
+	config.PostgresHost = c.String("db-host")
+	config.PostgresPort = c.Int("db-port")
+
+	return config, nil
+}
+
+func newMetadataStorage(config *env.Config) (api.MetadataStorage, error) {
+	esClientCtor := es.NewClient(config.ElasticEndpoint, false)
+	storageCtor := elastic.NewMetadataStorage(config.ESDatasetsIndex, false, esClientCtor)
+	return storageCtor()
+}
+
+func newStorage(config *env.Config) (api.MetadataStorage, api.DataStorage, error) {
+	esClientCtor := es.NewClient(config.ElasticEndpoint, false)
+	storageCtor := elastic.NewMetadataStorage(config.ESDatasetsIndex, false, esClientCtor)
+	storage, err := storageCtor()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	postgresClientCtor := postgres.NewClient(config.PostgresHost, config.PostgresPort, config.PostgresUser, config.PostgresPassword,
+		config.PostgresDatabase, config.PostgresLogLevel, false)
+	postgresBatchClientCtor := postgres.NewClient(config.PostgresHost, config.PostgresPort, config.PostgresUser, config.PostgresPassword,
+		config.PostgresDatabase, "error", true)
+	dataStorageCtor := pg.NewDataStorage(postgresClientCtor, postgresBatchClientCtor, storageCtor)
+	dataStorage, err := dataStorageCtor()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return storage, dataStorage, nil
+}
@@ -0,0 +1,350 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	"github.com/uncharted-distil/distil/api/postgres"
+	"github.com/uncharted-distil/distil/api/task"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	issueMissingTables  = "missing tables"
+	issueOrphanTables   = "orphan tables"
+	issueMissingColumns = "missing columns"
+	issueUnknownColumns = "unknown columns"
+	issueRowCount       = "row count"
+)
+
+// fsckIssue is an inconsistency found between the metadata index and the
+// postgres tables of a dataset.
+type fsckIssue struct {
+	DatasetID   string
+	StorageName string
+	Kind        string
+	Detail      string
+	Repaired    bool
+}
+
+func fsckCommand() cli.Command {
+	return cli.Command{
+		Name:      "fsck",
+		Usage:     "Check that the metadata index and the postgres tables agree on the ingested datasets",
+		UsageText: "distil-ingest fsck --es-endpoint=<url> --database=<name> [--delete-orphans] [--update-extremas]",
+		Flags: append(storageFlags(),
+			cli.BoolFlag{
+				Name:  "delete-orphans",
+				Usage: "Delete metadata without tables and tables without metadata",
+			},
+			cli.BoolFlag{
+				Name:  "update-extremas",
+				Usage: "Refresh the row count and extremas of datasets whose row counts do not match",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			config, err := loadStorageConfig(c)
+			if err != nil {
+				return err
+			}
+
+			issues, err := fsckDatasets(config, c.Bool("delete-orphans"), c.Bool("update-extremas"))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			err = writeFsckReport(os.Stdout, issues)
+			if err != nil {
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+
+			unrepaired := 0
+			for _, issue := range issues {
+				if !issue.Repaired {
+					unrepaired++
+				}
+			}
+			if unrepaired > 0 {
+				return cli.NewExitError(fmt.Sprintf("%d unrepaired inconsistencies found", unrepaired), 3)
+			}
+
+			return nil
+		},
+	}
+}
+
+func fsckDatasets(config *env.Config, deleteOrphans bool, updateExtremas bool) ([]*fsckIssue, error) {
+	storage, dataStorage, err := newStorage(config)
+	if err != nil {
+		return nil, err
+	}
+	pg, err := newDatabase(task.NewConfig(*config))
+	if err != nil {
+		return nil, err
+	}
+
+	storageNames, err := fetchStorageNames(config)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := fetchDatasetTables(pg)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(storageNames))
+	for id := range storageNames {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	issues := []*fsckIssue{}
+	referenced := map[string]bool{}
+	for _, id := range ids {
+		storageName := storageNames[id]
+		referenced[storageName] = true
+		log.Infof("checking dataset %s", id)
+
+		if !tables[storageName] {
+			issue := &fsckIssue{
+				DatasetID:   id,
+				StorageName: storageName,
+				Kind:        issueMissingTables,
+				Detail:      fmt.Sprintf("table %s%s does not exist", storageName, baseTableSuffix),
+			}
+			if deleteOrphans {
+				log.Infof("deleting metadata of dataset %s", id)
+				err = storage.DeleteDataset(id)
+				if err != nil {
+					return nil, err
+				}
+				issue.Repaired = true
+			}
+			issues = append(issues, issue)
+			continue
+		}
+
+		ds, err := storage.FetchDataset(id, true, true, true)
+		if err != nil {
+			return nil, err
+		}
+		if ds == nil {
+			continue
+		}
+
+		columns, err := fetchColumns(pg, fmt.Sprintf("%s%s", storageName, baseTableSuffix))
+		if err != nil {
+			return nil, err
+		}
+		columnNames := map[string]bool{}
+		for _, c := range columns {
+			columnNames[c.Name] = true
+		}
+		variableNames := map[string]bool{}
+		missing := []string{}
+		for _, v := range ds.Variables {
+			variableNames[v.Key] = true
+			// groupings are usually virtual and have no backing column
+			if !columnNames[v.Key] && !v.IsGrouping() {
+				missing = append(missing, v.Key)
+			}
+		}
+		unknown := []string{}
+		for _, c := range columns {
+			if !variableNames[c.Name] {
+				unknown = append(unknown, c.Name)
+			}
+		}
+		if len(missing) > 0 {
+			issues = append(issues, &fsckIssue{
+				DatasetID:   id,
+				StorageName: storageName,
+				Kind:        issueMissingColumns,
+				Detail:      strings.Join(missing, ", "),
+			})
+		}
+		if len(unknown) > 0 {
+			issues = append(issues, &fsckIssue{
+				DatasetID:   id,
+				StorageName: storageName,
+				Kind:        issueUnknownColumns,
+				Detail:      strings.Join(unknown, ", "),
+			})
+		}
+
+		numRows, err := dataStorage.FetchNumRows(storageName, nil)
+		if err != nil {
+			return nil, err
+		}
+		if int64(numRows) != ds.NumRows {
+			issue := &fsckIssue{
+				DatasetID:   id,
+				StorageName: storageName,
+				Kind:        issueRowCount,
+				Detail:      fmt.Sprintf("metadata has %d rows but table has %d", ds.NumRows, numRows),
+			}
+			if updateExtremas {
+				err = updateRowCount(config, id, storage, dataStorage)
+				if err != nil {
+					return nil, err
+				}
+				log.Infof("updating extremas of dataset %s", id)
+				err = task.UpdateExtremas(id, storage, dataStorage)
+				if err != nil {
+					return nil, err
+				}
+				issue.Repaired = true
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	orphans := []string{}
+	for storageName := range tables {
+		if !referenced[storageName] {
+			orphans = append(orphans, storageName)
+		}
+	}
+	sort.Strings(orphans)
+	for _, storageName := range orphans {
+		issue := &fsckIssue{
+			StorageName: storageName,
+			Kind:        issueOrphanTables,
+			Detail:      fmt.Sprintf("no dataset uses table %s%s", storageName, baseTableSuffix),
+		}
+		if deleteOrphans {
+			log.Infof("deleting tables of %s", storageName)
+			err = dataStorage.DeleteDataset(storageName)
+			if err != nil {
+				return nil, err
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+func writeFsckReport(w io.Writer, issues []*fsckIssue) error {
+	if len(issues) == 0 {
+		_, err := fmt.Fprintln(w, "no inconsistencies found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATASET\tSTORAGE NAME\tISSUE\tREPAIRED\tDETAIL")
+	for _, issue := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\n", listValue(issue.DatasetID), issue.StorageName, issue.Kind, issue.Repaired, issue.Detail)
+	}
+
+	return tw.Flush()
+}
+
+// fetchStorageNames reads the storage name of every dataset document, keyed
+// by dataset id. Unlike FetchDatasets it includes inference datasets since
+// they are also backed by tables. The documents are scrolled through so none
+// are missed, as a missing dataset would have its tables reported as orphans.
+func fetchStorageNames(config *env.Config) (map[string]string, error) {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	scroll := client.Scroll(config.ESDatasetsIndex).
+		Query(elastic.NewMatchAllQuery()).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("storageName", "datasetName")).
+		Size(datasetListSize)
+	defer scroll.Clear(context.Background())
+
+	storageNames := map[string]string{}
+	totalHits := int64(0)
+	for {
+		res, err := scroll.Do(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "elasticsearch dataset fetch query failed")
+		}
+		totalHits = res.TotalHits()
+
+		for _, hit := range res.Hits.Hits {
+			src := map[string]interface{}{}
+			err = json.Unmarshal(hit.Source, &src)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse dataset")
+			}
+			// mirror the defaults applied when distil parses datasets
+			storageName, _ := src["storageName"].(string)
+			if storageName == "" {
+				name, _ := src["datasetName"].(string)
+				if name == "" || name == "NULL" {
+					name = hit.Id
+				}
+				storageName = model.NormalizeDatasetID(name)
+			}
+			storageNames[hit.Id] = storageName
+		}
+	}
+	if int64(len(storageNames)) < totalHits {
+		return nil, errors.Errorf("read %d of %d dataset documents from index `%s`", len(storageNames), totalHits, config.ESDatasetsIndex)
+	}
+
+	return storageNames, nil
+}
+
+// fetchDatasetTables lists the storage names of the datasets found in the
+// postgres catalog.
+func fetchDatasetTables(pg *postgres.Database) (map[string]bool, error) {
+	rows, err := pg.Client.Query("SELECT table_name FROM information_schema.tables " +
+		"WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' AND table_name LIKE '%\\_base';")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list dataset tables")
+	}
+	defer rows.Close()
+
+	tables := map[string]bool{}
+	for rows.Next() {
+		var tableName string
+		err = rows.Scan(&tableName)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse dataset tables")
+		}
+		tables[strings.TrimSuffix(tableName, baseTableSuffix)] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list dataset tables")
+	}
+
+	return tables, nil
+}
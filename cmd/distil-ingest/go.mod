@@ -3,9 +3,14 @@ module github.com/uncharted-distil/distil-ingest/cmd/distil-ingest
 go 1.13
 
 require (
+	github.com/jackc/pgx/v4 v4.7.1
+	github.com/olivere/elastic/v7 v7.0.15
 	github.com/pkg/errors v0.9.1
 	github.com/uncharted-distil/distil v0.0.0-20210221181328-5e5b42f120fb
 	github.com/uncharted-distil/distil-compute v0.0.0-20210208222927-a7ae5d433614
+	github.com/uncharted-distil/distil-ingest/pkg v0.0.0
 	github.com/unchartedsoftware/plog v0.0.0-20200807135627-83d59e50ced5
 	github.com/urfave/cli v1.22.5
 )
+
+replace github.com/uncharted-distil/distil-ingest/pkg => ../../pkg
@@ -0,0 +1,118 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+)
+
+// targetImportanceField holds the importance of the variables against every
+// target ranked by distil-rank, keyed by target and then by variable. Like the
+// ingest time it is not part of the distil dataset mapping.
+const targetImportanceField = "targetImportance"
+
+// loadTargetImportance reads the importance against each target from a
+// ranking file. Files ranked against a single target, or by the ranking
+// pipeline, have no importance by target and return nil.
+func loadTargetImportance(filename string) (map[string][]float64, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", filename)
+	}
+
+	importance := struct {
+		Targets map[string][]float64 `json:"targets"`
+	}{}
+	err = json.Unmarshal(b, &importance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", filename)
+	}
+
+	return importance.Targets, nil
+}
+
+// recordTargetImportance stores the importance against each target on the
+// dataset document. Importance values follow the order of the variables, and
+// variables added after ranking have none.
+func recordTargetImportance(config *env.Config, datasetID string, variables []*model.Variable, targets map[string][]float64) error {
+	importance := map[string]map[string]float64{}
+	for target, features := range targets {
+		importance[target] = map[string]float64{}
+		for i, v := range variables {
+			if i < len(features) {
+				importance[target][v.Key] = features[i]
+			}
+		}
+	}
+
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			targetImportanceField: importance,
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to record target importance in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
+
+// fetchTargetImportance reads the importance against each target recorded
+// for the dataset, which is nil when none was recorded.
+func fetchTargetImportance(config *env.Config, datasetID string) (map[string]map[string]float64, error) {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Get().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "elasticsearch target importance query failed")
+	}
+
+	src := struct {
+		TargetImportance map[string]map[string]float64 `json:"targetImportance"`
+	}{}
+	err = json.Unmarshal(res.Source, &src)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse target importance")
+	}
+
+	return src.TargetImportance, nil
+}
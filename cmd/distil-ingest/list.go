@@ -0,0 +1,363 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// ingestTimeField is not part of the distil dataset mapping, so it is
+	// only present on datasets ingested since it started being recorded
+	ingestTimeField = "ingestTime"
+	// datasetListSize is the number of dataset documents read per scroll page
+	datasetListSize = 1000
+)
+
+func listCommand() cli.Command {
+	return cli.Command{
+		Name:      "list",
+		Usage:     "List the datasets in the metadata index",
+		UsageText: "distil-ingest list --es-endpoint=<url>",
+		Flags:     elasticFlags(),
+		Action: func(c *cli.Context) error {
+			config, err := loadElasticConfig(c)
+			if err != nil {
+				return err
+			}
+
+			err = listDatasets(os.Stdout, config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+
+			return nil
+		},
+	}
+}
+
+func inspectCommand() cli.Command {
+	return cli.Command{
+		Name:      "inspect",
+		Usage:     "Print the variables of a dataset in the metadata index",
+		UsageText: "distil-ingest inspect --es-endpoint=<url> [--database=<name>] <dataset id>",
+		ArgsUsage: "<dataset id>",
+		Flags:     storageFlags(),
+		Action: func(c *cli.Context) error {
+			if c.Args().First() == "" {
+				return cli.NewExitError("missing dataset id argument", 1)
+			}
+			// the extremas are only read from postgres when a database is given
+			extremas := c.String("database") != ""
+			config, err := loadElasticConfig(c)
+			if extremas {
+				config, err = loadStorageConfig(c)
+			}
+			if err != nil {
+				return err
+			}
+
+			err = inspectDataset(os.Stdout, c.Args().First(), extremas, config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+
+			return nil
+		},
+	}
+}
+
+func listDatasets(w io.Writer, config *env.Config) error {
+	storage, err := newMetadataStorage(config)
+	if err != nil {
+		return err
+	}
+	// the storage only lists the first page of non inference datasets, so the
+	// whole index is scrolled and every dataset fetched by id
+	datasetIDs, err := fetchDatasetIDs(config)
+	if err != nil {
+		return err
+	}
+	datasets := []*api.Dataset{}
+	for _, datasetID := range datasetIDs {
+		ds, err := storage.FetchDataset(datasetID, false, true, false)
+		if err != nil {
+			return err
+		}
+		if ds != nil {
+			datasets = append(datasets, ds)
+		}
+	}
+	ingestTimes, err := fetchIngestTimes(config)
+	if err != nil {
+		return err
+	}
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].ID < datasets[j].ID
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSOURCE\tTYPE\tROWS\tVARIABLES\tGROUPINGS\tINGESTED")
+	for _, ds := range datasets {
+		groupings := []string{}
+		for _, v := range ds.Variables {
+			if v.IsGrouping() {
+				groupings = append(groupings, v.Key)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", ds.ID, ds.Name, ds.Source, ds.Type, ds.NumRows,
+			len(ds.Variables), listValue(strings.Join(groupings, ",")), listValue(ingestTimes[ds.ID]))
+	}
+
+	return tw.Flush()
+}
+
+func inspectDataset(w io.Writer, datasetID string, extremas bool, config *env.Config) error {
+	var storage api.MetadataStorage
+	var dataStorage api.DataStorage
+	var err error
+	if extremas {
+		storage, dataStorage, err = newStorage(config)
+	} else {
+		storage, err = newMetadataStorage(config)
+	}
+	if err != nil {
+		return err
+	}
+	ds, err := storage.FetchDataset(datasetID, true, true, true)
+	if err != nil {
+		return err
+	}
+	if ds == nil {
+		return errors.Errorf("dataset '%s' not found in index '%s'", datasetID, config.ESDatasetsIndex)
+	}
+	ingestTimes, err := fetchIngestTimes(config)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", ds.ID)
+	fmt.Fprintf(tw, "Name:\t%s\n", ds.Name)
+	fmt.Fprintf(tw, "Storage name:\t%s\n", ds.StorageName)
+	fmt.Fprintf(tw, "Folder:\t%s\n", ds.Folder)
+	fmt.Fprintf(tw, "Source:\t%s\n", ds.Source)
+	fmt.Fprintf(tw, "Type:\t%s\n", ds.Type)
+	fmt.Fprintf(tw, "Rows:\t%d\n", ds.NumRows)
+	fmt.Fprintf(tw, "Bytes:\t%d\n", ds.NumBytes)
+	fmt.Fprintf(tw, "Ingested:\t%s\n", listValue(ingestTimes[ds.ID]))
+	fmt.Fprintln(tw)
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tKEY\tDISPLAY NAME\tROLE\tSELECTED TYPE\tORIGINAL TYPE\tSUGGESTED TYPES\tMIN\tMAX\tGROUPING")
+	for _, v := range ds.Variables {
+		min := ""
+		max := ""
+		if dataStorage != nil && model.IsNumerical(v.Type) {
+			extrema, err := dataStorage.FetchExtrema(ds.ID, ds.StorageName, v)
+			if err != nil {
+				log.Warnf("unable to fetch extremas of variable %s: %v", v.Key, err)
+			} else {
+				min = fmt.Sprintf("%v", extrema.Min)
+				max = fmt.Sprintf("%v", extrema.Max)
+			}
+		}
+		grouping := ""
+		if v.IsGrouping() {
+			grouping = v.Grouping.GetType()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Index, v.Key, v.DisplayName, listValue(v.SelectedRole),
+			v.Type, v.OriginalType, listValue(formatSuggestedTypes(v.SuggestedTypes)), listValue(min), listValue(max), listValue(grouping))
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	targetImportance, err := fetchTargetImportance(config, ds.ID)
+	if err != nil {
+		return err
+	}
+	if len(targetImportance) == 0 {
+		return nil
+	}
+	targets := []string{}
+	for target := range targetImportance {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "KEY\t%s\n", strings.Join(targets, "\t"))
+	for _, v := range ds.Variables {
+		values := make([]string, len(targets))
+		for i, target := range targets {
+			importance, ok := targetImportance[target][v.Key]
+			values[i] = "-"
+			if ok {
+				values[i] = fmt.Sprintf("%.4f", importance)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", v.Key, strings.Join(values, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// formatSuggestedTypes lists the suggested types from most to least probable.
+func formatSuggestedTypes(suggested []*model.SuggestedType) string {
+	sorted := make([]*model.SuggestedType, len(suggested))
+	copy(sorted, suggested)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Probability > sorted[j].Probability
+	})
+
+	formatted := make([]string, len(sorted))
+	for i, t := range sorted {
+		formatted[i] = fmt.Sprintf("%s (%.2f)", t.Type, t.Probability)
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+func listValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// fetchDatasetIDs reads the id of every document in the datasets index.
+func fetchDatasetIDs(config *env.Config) ([]string, error) {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	scroll := client.Scroll(config.ESDatasetsIndex).
+		Query(elastic.NewMatchAllQuery()).
+		FetchSource(false).
+		Size(datasetListSize)
+	defer scroll.Clear(context.Background())
+
+	datasetIDs := []string{}
+	totalHits := int64(0)
+	for {
+		res, err := scroll.Do(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "elasticsearch dataset fetch query failed")
+		}
+		totalHits = res.TotalHits()
+
+		for _, hit := range res.Hits.Hits {
+			datasetIDs = append(datasetIDs, hit.Id)
+		}
+	}
+	if int64(len(datasetIDs)) < totalHits {
+		return nil, errors.Errorf("read %d of %d dataset documents from index `%s`", len(datasetIDs), totalHits, config.ESDatasetsIndex)
+	}
+
+	return datasetIDs, nil
+}
+
+// fetchIngestTimes reads the recorded ingest time of every dataset that has
+// one, keyed by dataset id.
+func fetchIngestTimes(config *env.Config) (map[string]string, error) {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	scroll := client.Scroll(config.ESDatasetsIndex).
+		Query(elastic.NewExistsQuery(ingestTimeField)).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include(ingestTimeField)).
+		Size(datasetListSize)
+	defer scroll.Clear(context.Background())
+
+	ingestTimes := map[string]string{}
+	for {
+		res, err := scroll.Do(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "elasticsearch ingest time query failed")
+		}
+
+		for _, hit := range res.Hits.Hits {
+			src := map[string]interface{}{}
+			err = json.Unmarshal(hit.Source, &src)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse ingest time")
+			}
+			if ingestTime, ok := src[ingestTimeField].(string); ok {
+				ingestTimes[hit.Id] = ingestTime
+			}
+		}
+	}
+
+	return ingestTimes, nil
+}
+
+// recordIngestTime stamps the dataset document with the current time.
+func recordIngestTime(config *env.Config, datasetID string) error {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			ingestTimeField: time.Now().UTC().Format(time.RFC3339),
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to record ingest time in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
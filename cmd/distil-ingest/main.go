@@ -16,7 +16,9 @@
 package main
 
 import (
+	"context"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"time"
@@ -26,6 +28,7 @@ import (
 
 	"github.com/uncharted-distil/distil-compute/metadata"
 	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	distilds "github.com/uncharted-distil/distil/api/dataset"
 	es "github.com/uncharted-distil/distil/api/elastic"
 	"github.com/uncharted-distil/distil/api/env"
@@ -87,7 +90,7 @@ func main() {
 		cli.StringFlag{
 			Name:  "importance",
 			Value: "",
-			Usage: "The importance source path",
+			Usage: "The importance source path, which may hold the importance against several targets",
 		},
 		cli.StringFlag{
 			Name:  "es-endpoint",
@@ -148,6 +151,57 @@ func main() {
 			Value: 0.8,
 			Usage: "The threshold below which a classification result will be ignored and the type will default to unknown",
 		},
+		cli.BoolFlag{
+			Name:  "upsert",
+			Usage: "Update rows of an existing dataset that share a key and insert the rest instead of replacing the dataset",
+		},
+		cli.StringFlag{
+			Name:  "key",
+			Value: "",
+			Usage: "The variable used to match rows when upserting - defaults to the D3M index",
+		},
+		cli.StringFlag{
+			Name:  "type-overrides",
+			Value: "",
+			Usage: "A JSON or YAML file of variable names or patterns and the types to force on them after type verification",
+		},
+		cli.StringFlag{
+			Name:  "type-review",
+			Value: "",
+			Usage: "A type review CSV written by distil-classify whose selected types replace the classified types",
+		},
+		cli.StringSliceFlag{
+			Name:  "type-threshold",
+			Usage: "A type=threshold pair setting the probability below which a classified type defaults to unknown - can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "type-thresholds",
+			Value: "",
+			Usage: "A JSON or YAML file of per type probability thresholds",
+		},
+		cli.StringFlag{
+			Name:  "unknown-report",
+			Value: "",
+			Usage: "The CSV file listing the variables that fell back to unknown because of the type thresholds",
+		},
+		cli.StringFlag{
+			Name:  "raw",
+			Value: "",
+			Usage: "A CSV, TSV or parquet file to structure as a D3M dataset in a new <name>/<name>_dataset folder and ingest in place of the schema",
+		},
+		cli.BoolFlag{
+			Name:  "relational",
+			Usage: "Ingest each table resource of an unmerged dataset into its own table, recording the foreign keys between them",
+		},
+	}
+	app.Commands = []cli.Command{
+		snapshotCommand(),
+		restoreCommand(),
+		listCommand(),
+		inspectCommand(),
+		fsckCommand(),
+		modelsCommand(),
+		recomputeCommand(),
 	}
 	app.Action = func(c *cli.Context) error {
 
@@ -160,42 +214,85 @@ func main() {
 		if c.String("es-model-index") == "" && c.String("db-table") == "" {
 			return cli.NewExitError("missing commandline flag `--es-model-index` or `--db-table`", 1)
 		}
-		if c.String("dataset") == "" {
+		if c.String("dataset") == "" && c.String("raw") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
-		if c.String("dataset-folder") == "" {
-			return cli.NewExitError("missing commandline flag `--dataset-folder`", 1)
-		}
-		if c.String("classification") == "" {
-			return cli.NewExitError("missing commandline flag `--classification`", 1)
-		}
-		if c.String("summary") == "" {
-			return cli.NewExitError("missing commandline flag `--summary`", 1)
-		}
-		if c.String("summary-machine") == "" {
-			return cli.NewExitError("missing commandline flag `--summary-machine`", 1)
-		}
-		if c.String("importance") == "" {
-			return cli.NewExitError("missing commandline flag `--importance`", 1)
+		// a raw file has no dataset folder and no pipeline outputs yet, so the
+		// configured paths are used for any that are not given
+		if c.String("raw") == "" {
+			if c.String("dataset-folder") == "" {
+				return cli.NewExitError("missing commandline flag `--dataset-folder`", 1)
+			}
+			if c.String("classification") == "" {
+				return cli.NewExitError("missing commandline flag `--classification`", 1)
+			}
+			if c.String("summary") == "" {
+				return cli.NewExitError("missing commandline flag `--summary`", 1)
+			}
+			if c.String("summary-machine") == "" {
+				return cli.NewExitError("missing commandline flag `--summary-machine`", 1)
+			}
+			if c.String("importance") == "" {
+				return cli.NewExitError("missing commandline flag `--importance`", 1)
+			}
 		}
 
 		// initialize config
 		dataset := c.String("dataset")
 		schemaPath := c.String("schema")
 		metadataOnly := c.Bool("metadata-only")
+		upsert := c.Bool("upsert")
+		key := c.String("key")
 		config, err := env.LoadConfig()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
+		rules := &typeRules{
+			UnknownReportPath: c.String("unknown-report"),
+		}
+		// types without a threshold are only checked against the default when
+		// it is given, since ingests did not check it before
+		if c.IsSet("probability-threshold") {
+			rules.DefaultThreshold = c.Float64("probability-threshold")
+		}
+		if c.String("type-overrides") != "" {
+			rules.Overrides, err = loadTypeOverrides(filepath.Clean(c.String("type-overrides")))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+		}
+		if c.String("type-review") != "" {
+			// reviewed types come first so they win over the overrides file
+			reviewed, err := loadTypeReview(filepath.Clean(c.String("type-review")))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			rules.Overrides = append(reviewed, rules.Overrides...)
+		}
+		rules.Thresholds, err = loadTypeThresholds(c.String("type-thresholds"), c.StringSlice("type-threshold"))
+		if err != nil {
+			log.Errorf("%+v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
 		config.ElasticEndpoint = c.String("es-endpoint")
 		config.ESDatasetsIndex = c.String("es-metadata-index")
 		config.ESModelsIndex = c.String("es-model-index")
 		config.ElasticDatasetPrefix = c.String("es-dataset-prefix")
-		config.ClassificationOutputPath = filepath.Clean(c.String("classification"))
-		config.SummaryPath = filepath.Clean(c.String("summary"))
-		config.SummaryMachinePath = filepath.Clean(c.String("summary-machine"))
-		config.RankingOutputPath = filepath.Clean(c.String("importance"))
+		if c.String("classification") != "" {
+			config.ClassificationOutputPath = filepath.Clean(c.String("classification"))
+		}
+		if c.String("summary") != "" {
+			config.SummaryPath = filepath.Clean(c.String("summary"))
+		}
+		if c.String("summary-machine") != "" {
+			config.SummaryMachinePath = filepath.Clean(c.String("summary-machine"))
+		}
+		if c.String("importance") != "" {
+			config.RankingOutputPath = filepath.Clean(c.String("importance"))
+		}
 		config.ClassificationProbabilityThreshold = c.Float64("probability-threshold")
 		config.PostgresDatabase = c.String("database")
 		config.PostgresUser = c.String("db-user")
@@ -205,37 +302,103 @@ func main() {
 
 		ingestConfig := task.NewConfig(config)
 
-		if config.ElasticEndpoint != "" && !metadataOnly {
-			// ingest the metadata with retries in case of transient errors
-			for i := 0; i < 3; i++ {
-				err = ingestMetadata(dataset, schemaPath, &config, ingestConfig)
-				if err != nil {
-					log.Warnf("error on attempt %d: %+v", i, err)
-				} else {
-					break
-				}
+		if c.String("raw") != "" {
+			var dataPath string
+			schemaPath, dataPath, err = generateRawSchema(filepath.Clean(c.String("raw")))
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			if dataset == "" {
+				dataset = dataPath
+			}
+			log.Infof("generated schema written to %s", schemaPath)
+		}
 
-				time.Sleep(10 * time.Second)
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
+		if err != nil {
+			log.Errorf("%+v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
+
+		// a merged dataset is ingested as a single resource
+		resources := []*resourceDataset{{SchemaPath: schemaPath}}
+		fallbackMerged := true
+		if c.Bool("relational") {
+			if upsert {
+				return cli.NewExitError("`--upsert` cannot be combined with `--relational`", 1)
 			}
-			if err != nil {
-				log.Errorf("maximum number of retries reached with error")
-				os.Exit(1)
+			// the foreign keys are recorded on the dataset documents
+			if config.ElasticEndpoint == "" {
+				return cli.NewExitError("`--relational` requires `--es-endpoint`", 1)
 			}
-		} else if config.PostgresDatabase != "" {
-			err = ingestPostgres(dataset, schemaPath, &config, ingestConfig)
+			resources, err = splitResources(schemaPath, ingestConfig)
 			if err != nil {
-				log.Error(err)
-				os.Exit(1)
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			fallbackMerged = false
+		}
+
+		for _, resource := range resources {
+			if resource.ResID != "" {
+				log.Infof("ingesting resource %s as dataset %s", resource.ResID, resource.DatasetID)
+			}
+			if config.ElasticEndpoint != "" && !metadataOnly {
+				// ingest the metadata with retries in case of transient errors
+				for i := 0; i < 3; i++ {
+					err = ingestMetadata(dataset, resource.SchemaPath, upsert, fallbackMerged, rules, &config, ingestConfig)
+					if err != nil {
+						log.Warnf("error on attempt %d: %+v", i, err)
+					} else {
+						break
+					}
+
+					time.Sleep(10 * time.Second)
+				}
+				if err != nil {
+					log.Errorf("maximum number of retries reached with error")
+					os.Exit(1)
+				}
+				if resource.Relation != nil {
+					err = recordRelation(&config, resource.DatasetID, resource.Relation)
+					if err != nil {
+						log.Error(err)
+						os.Exit(1)
+					}
+				}
+			} else if config.PostgresDatabase != "" {
+				err = ingestPostgres(dataset, resource.SchemaPath, upsert, fallbackMerged, key, &config, ingestConfig)
+				if err != nil {
+					log.Error(err)
+					os.Exit(1)
+				}
+				// the dataset document may not have been indexed yet, in which
+				// case the relation is recorded by the metadata ingest
+				if resource.Relation != nil {
+					err = recordRelation(&config, resource.DatasetID, resource.Relation)
+					if err != nil {
+						log.Warnf("foreign keys of %s not recorded: %v", resource.DatasetID, err)
+					}
+				}
 			}
 		}
 
+		err = working.Finish()
+		if err != nil {
+			log.Errorf("%+v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
 
-func ingestMetadata(dataset string, schemaPath string, config *env.Config, ingestConfig *task.IngestTaskConfig) error {
+func ingestMetadata(dataset string, schemaPath string, upsert bool, fallbackMerged bool, rules *typeRules, config *env.Config, ingestConfig *task.IngestTaskConfig) error {
 	log.Infof("ingesting metadata for dataset %s", dataset)
 	esClientCtor := es.NewClient(ingestConfig.ESEndpoint, true)
 	log.Infof("creating datasets index '%s'", config.ESDatasetsIndex)
@@ -258,7 +421,7 @@ func ingestMetadata(dataset string, schemaPath string, config *env.Config, inges
 	}
 	steps := &task.IngestSteps{
 		VerifyMetadata: true,
-		FallbackMerged: true,
+		FallbackMerged: fallbackMerged,
 	}
 
 	_, err = task.IngestMetadata(schemaPath, schemaPath, nil, storage, params, ingestConfig, steps)
@@ -281,7 +444,15 @@ func ingestMetadata(dataset string, schemaPath string, config *env.Config, inges
 		return err
 	}
 
-	if isRemoteSensing(meta) {
+	if upsert {
+		// the row count loaded from file only covers the incoming rows
+		err = updateRowCount(config, meta.ID, storage, dataStorage)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isRemoteSensing(meta.GetMainDataResource().Variables) {
 		log.Infof("remote sensing dataset detected, so setting grouping info")
 		// set the remote sensing group
 		multiBandImageGroup := distilds.CreateSatelliteGrouping()
@@ -300,25 +471,87 @@ func ingestMetadata(dataset string, schemaPath string, config *env.Config, inges
 		}
 	}
 
+	fallbacks := []*unknownFallback{}
+	if len(rules.Thresholds) > 0 || rules.DefaultThreshold > 0 {
+		log.Infof("applying type thresholds")
+		fallbacks, err = applyTypeThresholds(meta.ID, rules.Thresholds, rules.DefaultThreshold, storage, dataStorage)
+		if err != nil {
+			return err
+		}
+		err = recordUnknownFallbacks(config, meta.ID, fallbacks)
+		if err != nil {
+			return err
+		}
+	}
+	if rules.UnknownReportPath != "" {
+		err = writeUnknownReport(rules.UnknownReportPath, fallbacks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(rules.Overrides) > 0 {
+		log.Infof("applying type overrides")
+		applied, err := applyTypeOverrides(meta.ID, rules.Overrides, storage, dataStorage)
+		if err != nil {
+			return err
+		}
+		err = recordTypeOverrides(config, meta.ID, applied)
+		if err != nil {
+			return err
+		}
+	}
+
 	log.Infof("updating extremas")
 	err = task.UpdateExtremas(meta.ID, storage, dataStorage)
 	if err != nil {
 		return err
 	}
 
+	targets, err := loadTargetImportance(path.Join(path.Dir(schemaPath), ingestConfig.RankingOutputPathRelative))
+	if err != nil {
+		return err
+	}
+	if len(targets) > 0 {
+		log.Infof("recording importance against %d targets", len(targets))
+		err = recordTargetImportance(config, meta.ID, meta.GetMainDataResource().Variables, targets)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordIngestTime(config, meta.ID)
+	if err != nil {
+		return err
+	}
+
 	log.Infof("done ingesting metadata for dataset %s", dataset)
 
 	return nil
 }
 
-func ingestPostgres(dataset string, schemaPath string, config *env.Config, ingestConfig *task.IngestTaskConfig) error {
+func ingestPostgres(dataset string, schemaPath string, upsert bool, fallbackMerged bool, key string, config *env.Config, ingestConfig *task.IngestTaskConfig) error {
+	if upsert {
+		log.Infof("starting postgres upsert for dataset %s", dataset)
+		result, ok, err := upsertPostgres(schemaPath, key, ingestConfig)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Infof("done postgres upsert for dataset %s (inserted: %d, updated: %d, unchanged: %d)",
+				dataset, result.Inserted, result.Updated, result.Unchanged)
+			return nil
+		}
+		log.Infof("falling back to a full ingest for dataset %s", dataset)
+	}
+
 	log.Infof("starting postgres ingest for dataset %s", dataset)
 	params := &task.IngestParams{
 		Source: metadata.Seed,
 	}
 	steps := &task.IngestSteps{
 		VerifyMetadata:       true,
-		FallbackMerged:       true,
+		FallbackMerged:       fallbackMerged,
 		CreateMetadataTables: true,
 	}
 	err := task.IngestPostgres(schemaPath, schemaPath, params, ingestConfig, steps)
@@ -330,10 +563,44 @@ func ingestPostgres(dataset string, schemaPath string, config *env.Config, inges
 	return nil
 }
 
-func isRemoteSensing(meta *model.Metadata) bool {
+// updateRowCount stores the row count of the dataset table on the dataset
+// document, leaving the rest of the document untouched.
+func updateRowCount(config *env.Config, datasetID string, storage api.MetadataStorage, dataStorage api.DataStorage) error {
+	ds, err := storage.FetchDataset(datasetID, true, true, true)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := dataStorage.FetchNumRows(ds.StorageName, nil)
+	if err != nil {
+		return err
+	}
+	log.Infof("updating row count of dataset %s from %d to %d", datasetID, ds.NumRows, numRows)
+
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			"numRows": numRows,
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to update row count in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
+
+func isRemoteSensing(variables []*model.Variable) bool {
 	// check for band and image file variables
 	vars := map[string]bool{}
-	for _, v := range meta.GetMainDataResource().Variables {
+	for _, v := range variables {
 		vars[v.Key] = true
 	}
 
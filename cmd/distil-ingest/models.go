@@ -0,0 +1,320 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil/api/compute"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	elastic "github.com/uncharted-distil/distil/api/model/storage/elastic"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// the D3M runtime writes pipeline scores as <pipeline id>.scores.csv
+	scoresFileSuffix = ".scores.csv"
+	modelScoresField = "scores"
+)
+
+// exportedPipeline holds the parts of a D3M pipeline description used to
+// describe the model.
+type exportedPipeline struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Steps       []interface{} `json:"steps"`
+}
+
+// modelScore is a single metric scored against a pipeline.
+type modelScore struct {
+	Metric     string  `json:"metric"`
+	Value      float64 `json:"value"`
+	Normalized float64 `json:"normalized,omitempty"`
+}
+
+func modelsCommand() cli.Command {
+	return cli.Command{
+		Name:      "models",
+		Usage:     "Ingest exported D3M pipelines and their scores into the models index",
+		UsageText: "distil-ingest models --input=<folder> --dataset=<id> --target=<name> --es-endpoint=<url>",
+		Flags: append(elasticFlags(),
+			cli.StringFlag{
+				Name:  "input",
+				Value: "",
+				Usage: "The folder holding the exported pipelines and scores",
+			},
+			cli.StringFlag{
+				Name:  "dataset",
+				Value: "",
+				Usage: "The id of the dataset the pipelines were fitted on",
+			},
+			cli.StringFlag{
+				Name:  "target",
+				Value: "",
+				Usage: "The target variable of the pipelines",
+			},
+			cli.StringFlag{
+				Name:  "problem",
+				Value: "",
+				Usage: "The problemDoc to read the target from when no target is specified",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.String("input") == "" {
+				return cli.NewExitError("missing commandline flag `--input`", 1)
+			}
+			if c.String("dataset") == "" {
+				return cli.NewExitError("missing commandline flag `--dataset`", 1)
+			}
+			if c.String("target") == "" && c.String("problem") == "" {
+				return cli.NewExitError("missing commandline flag `--target` or `--problem`", 1)
+			}
+			config, err := loadElasticConfig(c)
+			if err != nil {
+				return err
+			}
+
+			count, err := ingestModels(filepath.Clean(c.String("input")), c.String("dataset"), c.String("target"), c.String("problem"), config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("ingested %d models for dataset %s", count, c.String("dataset"))
+
+			return nil
+		},
+	}
+}
+
+func ingestModels(folder string, datasetID string, target string, problemPath string, config *env.Config) (int, error) {
+	if target == "" {
+		problem, err := compute.LoadProblemSchemaFromFile(problemPath)
+		if err != nil {
+			return 0, err
+		}
+		if len(problem.Inputs.Data) == 0 || len(problem.Inputs.Data[0].Targets) == 0 {
+			return 0, errors.Errorf("problem '%s' does not specify a target", problemPath)
+		}
+		target = problem.Inputs.Data[0].Targets[0].ColName
+	}
+
+	storage, err := newMetadataStorage(config)
+	if err != nil {
+		return 0, err
+	}
+	ds, err := storage.FetchDataset(datasetID, false, false, false)
+	if err != nil {
+		return 0, err
+	}
+	if ds == nil {
+		return 0, errors.Errorf("dataset '%s' not found in index '%s'", datasetID, config.ESDatasetsIndex)
+	}
+	targetVariable := findKeyVariable(ds.Variables, target)
+	if targetVariable == nil {
+		return 0, errors.Errorf("target variable '%s' not found in dataset '%s'", target, datasetID)
+	}
+
+	variables := []string{}
+	variableDetails := []*api.SolutionVariable{}
+	for _, v := range ds.Variables {
+		if v.Key == targetVariable.Key {
+			continue
+		}
+		variables = append(variables, v.Key)
+		variableDetails = append(variableDetails, api.SolutionVariableFromModelVariable(v, v.Importance))
+	}
+
+	pipelines, scorePaths, err := findExportedPipelines(folder)
+	if err != nil {
+		return 0, err
+	}
+	if len(pipelines) == 0 {
+		log.Warnf("no exported pipelines found in %s", folder)
+		return 0, nil
+	}
+
+	esClientCtor := es.NewClient(config.ElasticEndpoint, false)
+	log.Infof("creating models index '%s'", config.ESModelsIndex)
+	modelStorage, err := elastic.NewExportedModelStorage(config.ESModelsIndex, true, esClientCtor)()
+	if err != nil {
+		return 0, err
+	}
+	client, err := esClientCtor()
+	if err != nil {
+		return 0, err
+	}
+
+	for pipelinePath, pipeline := range pipelines {
+		name := pipeline.Name
+		if name == "" {
+			name = pipeline.ID
+		}
+		log.Infof("ingesting model %s from %s", name, pipelinePath)
+		err = modelStorage.PersistExportedModel(&api.ExportedModel{
+			ModelName:        name,
+			ModelDescription: pipeline.Description,
+			FilePath:         pipelinePath,
+			FittedSolutionID: pipeline.ID,
+			DatasetID:        ds.ID,
+			DatasetName:      ds.Name,
+			Target:           api.SolutionVariableFromModelVariable(targetVariable, -1),
+			Variables:        variables,
+			VariableDetails:  variableDetails,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		scorePath, ok := scorePaths[pipeline.ID]
+		if !ok {
+			log.Warnf("no scores found for model %s", name)
+			continue
+		}
+		scores, err := readModelScores(scorePath)
+		if err != nil {
+			return 0, err
+		}
+
+		// the exported model type has no scores so they are added to the document separately
+		_, err = client.Update().
+			Index(config.ESModelsIndex).
+			Id(pipeline.ID).
+			Doc(map[string]interface{}{
+				modelScoresField: scores,
+			}).
+			Refresh("true").
+			Do(context.Background())
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to add scores to index `%s`", config.ESModelsIndex)
+		}
+	}
+
+	return len(pipelines), nil
+}
+
+// findExportedPipelines walks the folder for D3M pipeline descriptions, keyed
+// by file path, and score files, keyed by pipeline id.
+func findExportedPipelines(folder string) (map[string]*exportedPipeline, map[string]string, error) {
+	pipelines := map[string]*exportedPipeline{}
+	scorePaths := map[string]string{}
+	err := filepath.Walk(folder, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		if strings.HasSuffix(info.Name(), scoresFileSuffix) {
+			scorePaths[strings.TrimSuffix(info.Name(), scoresFileSuffix)] = filename
+			return nil
+		}
+		if filepath.Ext(filename) != ".json" {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read %s", filename)
+		}
+		pipeline := &exportedPipeline{}
+		err = json.Unmarshal(b, pipeline)
+		if err != nil || pipeline.ID == "" || pipeline.Steps == nil {
+			log.Infof("skipping %s since it is not a pipeline description", filename)
+			return nil
+		}
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return errors.Wrapf(err, "unable to resolve %s", filename)
+		}
+		pipelines[abs] = pipeline
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to search %s for pipelines", folder)
+	}
+
+	return pipelines, scorePaths, nil
+}
+
+// readModelScores parses a D3M runtime score file, which has a header row
+// with at least the metric and value columns.
+func readModelScores(filename string) ([]*modelScore, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %s", filename)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", filename)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, c := range lines[0] {
+		columns[strings.ToLower(strings.TrimSpace(c))] = i
+	}
+	metricIndex, ok := columns["metric"]
+	if !ok {
+		return nil, errors.Errorf("score file %s has no metric column", filename)
+	}
+	valueIndex, ok := columns["value"]
+	if !ok {
+		return nil, errors.Errorf("score file %s has no value column", filename)
+	}
+	normalizedIndex, hasNormalized := columns["normalized"]
+
+	scores := []*modelScore{}
+	for _, line := range lines[1:] {
+		if len(line) <= metricIndex || len(line) <= valueIndex {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[valueIndex], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse score of metric %s", line[metricIndex])
+		}
+		score := &modelScore{
+			Metric: line[metricIndex],
+			Value:  value,
+		}
+		if hasNormalized && len(line) > normalizedIndex {
+			score.Normalized, _ = strconv.ParseFloat(line[normalizedIndex], 64)
+		}
+		scores = append(scores, score)
+	}
+
+	return scores, nil
+}
@@ -0,0 +1,149 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindExportedPipelines(t *testing.T) {
+	folder, err := ioutil.TempDir("", "models-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	err = os.MkdirAll(path.Join(folder, "unscored"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"scored.json":         `{"id": "p1", "name": "scored", "steps": [{"type": "PRIMITIVE"}]}`,
+		"p1.scores.csv":       "metric,value\naccuracy,0.9\n",
+		"unscored/p2.json":    `{"id": "p2", "steps": []}`,
+		"datasetDoc.json":     `{"about": {"datasetID": "d"}}`,
+		"malformed.json":      `{"id": `,
+		"unscored/notes.txt":  "not a pipeline",
+		"orphan.scores.csv":   "metric,value\n",
+		"unscored/no_id.json": `{"steps": []}`,
+		"unscored/steps.json": `{"id": "p3"}`,
+	}
+	for filename, content := range files {
+		err = ioutil.WriteFile(path.Join(folder, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pipelines, scorePaths, err := findExportedPipelines(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		path.Join(abs, "scored.json"):      "p1",
+		path.Join(abs, "unscored/p2.json"): "p2",
+	}
+	actual := map[string]string{}
+	for filename, pipeline := range pipelines {
+		actual[filename] = pipeline.ID
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("findExportedPipelines pipelines = %v, expected %v", actual, expected)
+	}
+
+	expectedScores := map[string]string{
+		"p1":     path.Join(folder, "p1.scores.csv"),
+		"orphan": path.Join(folder, "orphan.scores.csv"),
+	}
+	if !reflect.DeepEqual(scorePaths, expectedScores) {
+		t.Errorf("findExportedPipelines scores = %v, expected %v", scorePaths, expectedScores)
+	}
+	if _, ok := scorePaths["p2"]; ok {
+		t.Errorf("findExportedPipelines found scores for the unscored pipeline")
+	}
+
+	_, _, err = findExportedPipelines(path.Join(folder, "missing"))
+	if err == nil {
+		t.Errorf("findExportedPipelines of a missing folder returned no error")
+	}
+}
+
+func TestReadModelScores(t *testing.T) {
+	folder, err := ioutil.TempDir("", "models-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	tests := []struct {
+		name     string
+		content  string
+		expected []*modelScore
+		fails    bool
+	}{
+		{"scores", "metric,value,normalized\naccuracy,0.9,0.9\nf1Macro,0.75,0.8\n",
+			[]*modelScore{{Metric: "accuracy", Value: 0.9, Normalized: 0.9}, {Metric: "f1Macro", Value: 0.75, Normalized: 0.8}}, false},
+		{"header case and extra columns", " Metric ,randomSeed,VALUE\nrmse,0,1.5\n",
+			[]*modelScore{{Metric: "rmse", Value: 1.5}}, false},
+		{"short rows skipped", "metric,value,normalized\naccuracy\nf1,0.5\n",
+			[]*modelScore{{Metric: "f1", Value: 0.5}}, false},
+		{"unparsed normalized", "metric,value,normalized\naccuracy,0.9,n/a\n",
+			[]*modelScore{{Metric: "accuracy", Value: 0.9}}, false},
+		{"header only", "metric,value\n", []*modelScore{}, false},
+		{"empty", "", nil, false},
+		{"unparsed value", "metric,value\naccuracy,high\n", nil, true},
+		{"no metric column", "name,value\naccuracy,0.9\n", nil, true},
+		{"no value column", "metric,score\naccuracy,0.9\n", nil, true},
+		{"unbalanced quotes", "metric,value\n\"accuracy,0.9\n", nil, true},
+	}
+	for i, test := range tests {
+		filename := path.Join(folder, fmt.Sprintf("p%d%s", i, scoresFileSuffix))
+		err = ioutil.WriteFile(filename, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := readModelScores(filename)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: readModelScores returned no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: readModelScores returned %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: readModelScores = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+
+	_, err = readModelScores(path.Join(folder, "missing"+scoresFileSuffix))
+	if err == nil {
+		t.Errorf("readModelScores of a missing file returned no error")
+	}
+}
@@ -0,0 +1,143 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/configfile"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	log "github.com/unchartedsoftware/plog"
+)
+
+// typeOverridesField holds the types set by the overrides on the dataset
+// document so they survive a recompute of the suggested types.
+const typeOverridesField = "typeOverrides"
+
+// typeOverrides is the content of a type overrides file. Rules are checked in
+// order and the first one matching a variable sets its type.
+type typeOverrides struct {
+	Overrides []*typeOverride `json:"overrides" yaml:"overrides"`
+}
+
+// typeOverride sets the type of the variables matching either the variable
+// name or the pattern.
+type typeOverride struct {
+	Variable string `json:"variable" yaml:"variable"`
+	Pattern  string `json:"pattern" yaml:"pattern"`
+	Type     string `json:"type" yaml:"type"`
+	regex    *regexp.Regexp
+}
+
+func (o *typeOverride) matches(v *model.Variable) bool {
+	if o.regex != nil {
+		return o.regex.MatchString(v.Key) || o.regex.MatchString(v.HeaderName)
+	}
+	return o.Variable == v.Key || o.Variable == v.HeaderName
+}
+
+// loadTypeOverrides reads a JSON or YAML type overrides file.
+func loadTypeOverrides(filename string) ([]*typeOverride, error) {
+	overrides := &typeOverrides{}
+	err := configfile.Read(filename, overrides)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load type overrides")
+	}
+
+	for i, o := range overrides.Overrides {
+		if (o.Variable == "") == (o.Pattern == "") {
+			return nil, errors.Errorf("type override %d needs exactly one of variable or pattern", i)
+		}
+		if model.MapTA2Type(o.Type) == "" {
+			return nil, errors.Errorf("type override %d has unsupported type '%s'", i, o.Type)
+		}
+		if o.Pattern != "" {
+			// match the whole name so a pattern like 'zip' does not catch 'zipper'
+			o.regex, err = regexp.Compile("^(?:" + o.Pattern + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "type override %d has an invalid pattern", i)
+			}
+		}
+	}
+
+	return overrides.Overrides, nil
+}
+
+// applyTypeOverrides sets the type of every variable matched by an override
+// in both the metadata and the data storage, returning the type set for each
+// matched variable.
+func applyTypeOverrides(datasetID string, overrides []*typeOverride, storage api.MetadataStorage, dataStorage api.DataStorage) (map[string]string, error) {
+	ds, err := storage.FetchDataset(datasetID, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if ds == nil {
+		return nil, errors.Errorf("dataset '%s' not found", datasetID)
+	}
+
+	applied := map[string]string{}
+	for _, v := range ds.Variables {
+		for _, o := range overrides {
+			if !o.matches(v) {
+				continue
+			}
+			if v.Type != o.Type {
+				log.Infof("overriding type of variable %s from %s to %s", v.Key, v.Type, o.Type)
+				err = dataStorage.SetDataType(ds.ID, ds.StorageName, v.Key, o.Type)
+				if err != nil {
+					return nil, errors.Wrapf(err, "unable to override type of variable %s", v.Key)
+				}
+				err = storage.SetDataType(ds.ID, v.Key, o.Type)
+				if err != nil {
+					return nil, errors.Wrapf(err, "unable to override type of variable %s", v.Key)
+				}
+			}
+			applied[v.Key] = o.Type
+			break
+		}
+	}
+
+	return applied, nil
+}
+
+// recordTypeOverrides stores the types set by the overrides on the dataset
+// document.
+func recordTypeOverrides(config *env.Config, datasetID string, types map[string]string) error {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			typeOverridesField: types,
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to record type overrides in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
@@ -0,0 +1,353 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
+	"github.com/uncharted-distil/distil/api/serialization"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// rawSniffLines is the number of lines read to pick the delimiter
+	rawSniffLines = 20
+	// maxCategories is the largest number of distinct values a column can
+	// have and still be considered categorical
+	maxCategories = 20
+)
+
+var (
+	rawDelimiters = []rune{',', '\t', ';', '|'}
+
+	rawDateLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		"2006/01/02",
+		"01/02/2006",
+		"1/2/2006",
+	}
+
+	rawBooleans = map[string]bool{
+		"true": true, "false": true, "t": true, "f": true, "yes": true, "no": true,
+	}
+)
+
+// generateRawSchema structures a raw CSV, TSV or parquet file as a D3M dataset
+// in a new `<name>/<name>_dataset` folder next to the file, following the seed
+// layout the ingest takes the dataset folder from. The data is rewritten as
+// a comma separated file with a header and a d3mIndex, and the schema is
+// written next to it with types inferred from the values, to be refined by the
+// classification. It returns the paths of the schema and the data, and fails
+// rather than overwrite a dataset generated before.
+func generateRawSchema(rawPath string) (string, string, error) {
+	lines, header, err := readRawFile(rawPath)
+	if err != nil {
+		return "", "", err
+	}
+	if len(lines) == 0 {
+		return "", "", errors.Errorf("raw file %s is empty", rawPath)
+	}
+
+	columnCount := 0
+	for _, line := range lines {
+		if len(line) > columnCount {
+			columnCount = len(line)
+		}
+	}
+	for i, line := range lines {
+		for len(line) < columnCount {
+			line = append(line, "")
+		}
+		lines[i] = line
+	}
+
+	var names []string
+	rows := lines
+	if header || hasHeader(lines) {
+		names = columnNames(lines[0])
+		rows = lines[1:]
+	} else {
+		names = columnNames(make([]string, columnCount))
+	}
+	log.Infof("read %d rows and %d columns from %s (header %v)", len(rows), columnCount, rawPath, len(rows) < len(lines))
+
+	// add an index numbering the rows unless the file has one
+	indexColumn := -1
+	for i, name := range names {
+		if name == model.D3MIndexFieldName {
+			indexColumn = i
+		}
+	}
+	if indexColumn < 0 {
+		names = append([]string{model.D3MIndexFieldName}, names...)
+		for i, row := range rows {
+			rows[i] = append([]string{strconv.Itoa(i)}, row...)
+		}
+		indexColumn = 0
+	}
+
+	name := strings.TrimSuffix(filepath.Base(rawPath), filepath.Ext(rawPath))
+	meta := model.NewMetadata(name, name, "", model.NormalizeDatasetID(name))
+	dr := model.NewDataResource(compute.DefaultResourceID, model.ResTypeTable, map[string][]string{compute.D3MResourceFormat: {"csv"}})
+	for i, columnName := range names {
+		typ := model.IntegerType
+		role := model.RoleIndex
+		distilRole := model.VarDistilRoleIndex
+		if i != indexColumn {
+			values := make([]string, len(rows))
+			for j, row := range rows {
+				values[j] = row[i]
+			}
+			typ = inferRawType(values)
+			role = model.RoleAttribute
+			distilRole = model.VarDistilRoleData
+		}
+		dr.Variables = append(dr.Variables, model.NewVariable(i, columnName, columnName, columnName, columnName, typ, typ,
+			"", []string{role}, distilRole, nil, dr.Variables, false))
+	}
+	meta.DataResources = []*model.DataResource{dr}
+
+	// the data path is kept relative so the dataset folder can be moved
+	folder := path.Join(path.Dir(rawPath), name, name+"_dataset")
+	relativeData := path.Join(compute.D3MDataFolder, compute.D3MLearningData)
+	outputData := path.Join(folder, relativeData)
+	schemaPath := path.Join(folder, compute.D3MDataSchema)
+	for _, existing := range []string{schemaPath, outputData} {
+		if _, err := os.Stat(existing); err == nil {
+			return "", "", errors.Errorf("%s already exists, remove it to structure %s again", existing, rawPath)
+		}
+	}
+	datasetStorage := serialization.GetStorage(outputData)
+	err = datasetStorage.WriteData(outputData, append([][]string{names}, rows...))
+	if err != nil {
+		return "", "", errors.Wrap(err, "error writing formatted raw data")
+	}
+	dr.ResPath = relativeData
+
+	err = datasetStorage.WriteMetadata(schemaPath, meta, true, false)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to store generated schema")
+	}
+
+	return schemaPath, outputData, nil
+}
+
+// readRawFile reads the lines of a raw file. Parquet files are read with
+// their column names as the header, other files are read as delimited text.
+func readRawFile(rawPath string) ([][]string, bool, error) {
+	if parquet.IsParquet(rawPath) {
+		lines, err := serialization.GetParquetStorage().ReadData(rawPath)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to read raw file")
+		}
+		return lines, true, nil
+	}
+
+	delimiter, err := sniffDelimiter(rawPath)
+	if err != nil {
+		return nil, false, err
+	}
+	log.Infof("reading %s with delimiter %q", rawPath, delimiter)
+
+	file, err := os.Open(rawPath)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to open raw file")
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to read raw file")
+	}
+
+	return lines, false, nil
+}
+
+// sniffDelimiter picks the delimiter that splits the first lines into the
+// same number of fields, preferring the one giving the most fields. Files
+// with a .tsv extension are tab separated.
+func sniffDelimiter(rawPath string) (rune, error) {
+	if strings.EqualFold(filepath.Ext(rawPath), ".tsv") {
+		return '\t', nil
+	}
+
+	file, err := os.Open(rawPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open raw file")
+	}
+	defer file.Close()
+
+	sample := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for len(sample) < rawSniffLines && scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			sample = append(sample, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Wrap(err, "failed to read raw file")
+	}
+
+	best := ','
+	bestFields := 1
+	for _, delimiter := range rawDelimiters {
+		reader := csv.NewReader(strings.NewReader(strings.Join(sample, "\n")))
+		reader.Comma = delimiter
+		reader.LazyQuotes = true
+		lines, err := reader.ReadAll()
+		if err != nil || len(lines) == 0 {
+			// inconsistent field counts
+			continue
+		}
+		if len(lines[0]) > bestFields {
+			best = delimiter
+			bestFields = len(lines[0])
+		}
+	}
+
+	return best, nil
+}
+
+// hasHeader checks whether the first line names the columns. A header has
+// distinct non empty values, none of them numbers, and either a column below
+// it holds numbers or none of its values are repeated below it.
+func hasHeader(lines [][]string) bool {
+	if len(lines) < 2 {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for _, value := range lines[0] {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] || isNumber(value) {
+			return false
+		}
+		seen[value] = true
+	}
+
+	repeated := false
+	for i, value := range lines[0] {
+		values := make([]string, 0, len(lines)-1)
+		for _, line := range lines[1:] {
+			values = append(values, line[i])
+			repeated = repeated || line[i] == value
+		}
+		if t := inferRawType(values); t == model.IntegerType || t == model.RealType {
+			return true
+		}
+	}
+
+	return !repeated
+}
+
+// columnNames cleans up the header names, naming empty columns by position
+// and numbering repeated names.
+func columnNames(header []string) []string {
+	names := make([]string, len(header))
+	counts := map[string]int{}
+	for i, value := range header {
+		name := strings.TrimFunc(value, func(r rune) bool {
+			return unicode.IsSpace(r) || !unicode.IsGraphic(r)
+		})
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		counts[name]++
+		if counts[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, counts[name])
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// inferRawType picks a preliminary type from the non empty values.
+func inferRawType(values []string) string {
+	integers, reals, booleans, dates, count := 0, 0, 0, 0, 0
+	distinct := map[string]bool{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		count++
+		distinct[value] = true
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			integers++
+		}
+		if isNumber(value) {
+			reals++
+		}
+		if rawBooleans[strings.ToLower(value)] {
+			booleans++
+		}
+		if isDate(value) {
+			dates++
+		}
+	}
+
+	switch {
+	case count == 0:
+		return model.StringType
+	case integers == count:
+		return model.IntegerType
+	case reals == count:
+		return model.RealType
+	case booleans == count:
+		return model.BoolType
+	case dates == count:
+		return model.DateTimeType
+	case len(distinct) <= maxCategories && len(distinct) < count:
+		return model.CategoricalType
+	default:
+		return model.StringType
+	}
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
+
+func isDate(value string) bool {
+	for _, layout := range rawDateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
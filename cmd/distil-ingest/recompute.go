@@ -0,0 +1,297 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	"github.com/uncharted-distil/distil/api/task"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	recomputeSucceeded = "ok"
+	recomputeFailed    = "failed"
+	recomputeSkipped   = "skipped"
+)
+
+// recomputeStep reruns a single ingest step against an ingested dataset. It
+// returns false if the step does not apply to the dataset.
+type recomputeStep func(ds *api.Dataset, config *env.Config, storage api.MetadataStorage, dataStorage api.DataStorage) (bool, error)
+
+// recomputeResult is the outcome of running a step against a dataset.
+type recomputeResult struct {
+	DatasetID string
+	Status    string
+	Duration  time.Duration
+	Err       error
+}
+
+func recomputeCommand() cli.Command {
+	flags := append(storageFlags(),
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "Run against every dataset in the metadata index",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 4,
+			Usage: "The number of datasets to process at once",
+		},
+	)
+
+	return cli.Command{
+		Name:  "recompute",
+		Usage: "Rerun ingest steps against already ingested datasets",
+		Subcommands: []cli.Command{
+			{
+				Name:      "extremas",
+				Usage:     "Recompute the variable extremas from the stored data",
+				ArgsUsage: "[dataset id...]",
+				Flags:     flags,
+				Action: func(c *cli.Context) error {
+					return runRecompute(c, "extremas", recomputeExtremas)
+				},
+			},
+			{
+				Name:      "types",
+				Usage:     "Verify the suggested types against the stored data",
+				ArgsUsage: "[dataset id...]",
+				Flags:     flags,
+				Action: func(c *cli.Context) error {
+					return runRecompute(c, "types", recomputeTypes)
+				},
+			},
+		},
+	}
+}
+
+func recomputeExtremas(ds *api.Dataset, config *env.Config, storage api.MetadataStorage, dataStorage api.DataStorage) (bool, error) {
+	return true, task.UpdateExtremas(ds.ID, storage, dataStorage)
+}
+
+// recordedTypes are the types set at ingest once the suggested types were
+// verified, which a verification would otherwise replace.
+type recordedTypes struct {
+	TypeOverrides    map[string]string `json:"typeOverrides"`
+	UnknownFallbacks []string          `json:"unknownFallbacks"`
+}
+
+// overrides returns the rules setting the recorded types again. The type
+// overrides come first so they win over the unknown fallbacks, as they were
+// applied after the thresholds at ingest.
+func (r *recordedTypes) overrides() []*typeOverride {
+	keys := make([]string, 0, len(r.TypeOverrides))
+	for key := range r.TypeOverrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	overrides := []*typeOverride{}
+	for _, key := range keys {
+		overrides = append(overrides, &typeOverride{Variable: key, Type: r.TypeOverrides[key]})
+	}
+	for _, key := range r.UnknownFallbacks {
+		overrides = append(overrides, &typeOverride{Variable: key, Type: model.UnknownType})
+	}
+
+	return overrides
+}
+
+// recomputeTypes verifies the suggested types again and then sets the types
+// chosen by the type overrides and thresholds at ingest.
+func recomputeTypes(ds *api.Dataset, config *env.Config, storage api.MetadataStorage, dataStorage api.DataStorage) (bool, error) {
+	// remote sensing datasets get their types from the grouping set at ingest
+	if isRemoteSensing(ds.Variables) {
+		return false, nil
+	}
+
+	recorded, err := fetchRecordedTypes(config, ds.ID)
+	if err != nil {
+		return true, err
+	}
+	err = task.VerifySuggestedTypes(ds.ID, dataStorage, storage)
+	if err != nil {
+		return true, err
+	}
+
+	overrides := recorded.overrides()
+	if len(overrides) == 0 {
+		return true, nil
+	}
+	_, err = applyTypeOverrides(ds.ID, overrides, storage, dataStorage)
+	return true, err
+}
+
+// fetchRecordedTypes reads the types set at ingest from the dataset document.
+func fetchRecordedTypes(config *env.Config, datasetID string) (*recordedTypes, error) {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Get().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "elasticsearch recorded types query failed")
+	}
+
+	recorded := &recordedTypes{}
+	err = json.Unmarshal(res.Source, recorded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse recorded types")
+	}
+
+	return recorded, nil
+}
+
+func runRecompute(c *cli.Context, name string, step recomputeStep) error {
+	if !c.Bool("all") && c.NArg() == 0 {
+		return cli.NewExitError("missing dataset id arguments or commandline flag `--all`", 1)
+	}
+	if c.Bool("all") && c.NArg() > 0 {
+		return cli.NewExitError("dataset id arguments cannot be combined with `--all`", 1)
+	}
+	config, err := loadStorageConfig(c)
+	if err != nil {
+		return err
+	}
+
+	ids := []string(c.Args())
+	if c.Bool("all") {
+		storageNames, err := fetchStorageNames(config)
+		if err != nil {
+			log.Errorf("%+v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		for id := range storageNames {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	storage, dataStorage, err := newStorage(config)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return cli.NewExitError(errors.Cause(err), 2)
+	}
+
+	results := recomputeDatasets(ids, name, step, c.Int("concurrency"), config, storage, dataStorage)
+	err = writeRecomputeReport(os.Stdout, results)
+	if err != nil {
+		return cli.NewExitError(errors.Cause(err), 2)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status == recomputeFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%s failed for %d of %d datasets", name, failed, len(results)), 3)
+	}
+
+	return nil
+}
+
+// recomputeDatasets runs the step against the datasets using a fixed number
+// of workers, returning the results in the order of the ids.
+func recomputeDatasets(ids []string, name string, step recomputeStep, concurrency int, config *env.Config, storage api.MetadataStorage, dataStorage api.DataStorage) []*recomputeResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*recomputeResult, len(ids))
+	indices := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = recomputeDataset(ids[i], name, step, config, storage, dataStorage)
+			}
+		}()
+	}
+	for i := range ids {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return results
+}
+
+func recomputeDataset(datasetID string, name string, step recomputeStep, config *env.Config, storage api.MetadataStorage, dataStorage api.DataStorage) *recomputeResult {
+	log.Infof("recomputing %s for dataset %s", name, datasetID)
+	start := time.Now()
+	result := &recomputeResult{
+		DatasetID: datasetID,
+		Status:    recomputeSucceeded,
+	}
+
+	ds, err := storage.FetchDataset(datasetID, true, true, true)
+	if err == nil && ds == nil {
+		err = errors.Errorf("dataset '%s' not found", datasetID)
+	}
+	if err == nil {
+		var applied bool
+		applied, err = step(ds, config, storage, dataStorage)
+		if !applied {
+			result.Status = recomputeSkipped
+		}
+	}
+	if err != nil {
+		log.Warnf("recomputing %s for dataset %s failed: %+v", name, datasetID, err)
+		result.Status = recomputeFailed
+		result.Err = err
+	}
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+func writeRecomputeReport(w io.Writer, results []*recomputeResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATASET\tSTATUS\tDURATION\tERROR")
+	for _, result := range results {
+		message := ""
+		if result.Err != nil {
+			message = errors.Cause(result.Err).Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.DatasetID, result.Status, result.Duration.Round(time.Millisecond), listValue(message))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestRecordedTypesOverrides(t *testing.T) {
+	recorded := &recordedTypes{
+		TypeOverrides:    map[string]string{"zip": model.StringType, "code": model.CategoricalType},
+		UnknownFallbacks: []string{"when", "code"},
+	}
+	overrides := recorded.overrides()
+
+	tests := []struct {
+		name     string
+		variable *model.Variable
+		expected string
+	}{
+		{"override", &model.Variable{Key: "zip"}, model.StringType},
+		{"threshold fallback", &model.Variable{Key: "when"}, model.UnknownType},
+		{"override wins over fallback", &model.Variable{Key: "code"}, model.CategoricalType},
+		{"unrecorded", &model.Variable{Key: "count"}, ""},
+	}
+	for _, test := range tests {
+		actual := ""
+		for _, o := range overrides {
+			if o.matches(test.variable) {
+				actual = o.Type
+				break
+			}
+		}
+		if actual != test.expected {
+			t.Errorf("%s: override type of %s = '%s', expected '%s'", test.name, test.variable.Key, actual, test.expected)
+		}
+	}
+
+	if actual := (&recordedTypes{}).overrides(); len(actual) != 0 {
+		t.Errorf("overrides of no recorded types = %d, expected 0", len(actual))
+	}
+}
@@ -0,0 +1,286 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	"github.com/uncharted-distil/distil/api/serialization"
+	"github.com/uncharted-distil/distil/api/task"
+)
+
+// relationalField links a dataset ingested from a single resource to the
+// dataset it came from and the datasets its foreign keys refer to. Like the
+// ingest time it is not part of the distil dataset mapping.
+const relationalField = "relational"
+
+// resourceDataset is a table resource of a dataset written out as a dataset
+// of its own so it can be ingested into its own table.
+type resourceDataset struct {
+	ResID      string
+	DatasetID  string
+	SchemaPath string
+	Relation   *resourceRelation
+}
+
+// resourceRelation is what is recorded of the source dataset on each dataset
+// ingested from one of its resources.
+type resourceRelation struct {
+	Dataset     string                `json:"dataset"`
+	ResID       string                `json:"resID"`
+	ForeignKeys []*resourceForeignKey `json:"foreignKeys"`
+}
+
+// resourceForeignKey is a variable holding keys of a column of the dataset
+// ingested from another resource.
+type resourceForeignKey struct {
+	Variable string `json:"variable"`
+	Dataset  string `json:"dataset"`
+	ResID    string `json:"resID"`
+	Column   string `json:"column"`
+}
+
+// splitResources writes every table resource of the dataset as a dataset of
+// its own in a folder next to the dataset. The main resource keeps the
+// dataset ID and the others get the resource ID appended. Resources without a
+// d3mIndex get one numbering their rows, and the others read the data in
+// place. The ranking and summaries carry over to the main resource, along
+// with the classification when it was run on its columns.
+func splitResources(schemaPath string, ingestConfig *task.IngestTaskConfig) ([]*resourceDataset, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaPath, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	datasetIDs := map[string]string{}
+	for _, dr := range meta.DataResources {
+		if dr.ResType != model.ResTypeTable {
+			continue
+		}
+		datasetIDs[dr.ResID] = meta.ID
+		if dr != mainDR {
+			datasetIDs[dr.ResID] = fmt.Sprintf("%s_%s", meta.ID, dr.ResID)
+		}
+	}
+
+	datasetFolder := path.Dir(schemaPath)
+	resourcesFolder := path.Join(path.Dir(datasetFolder), path.Base(datasetFolder)+"-resources")
+	resources := []*resourceDataset{}
+	for _, dr := range meta.DataResources {
+		if dr.ResType != model.ResTypeTable {
+			continue
+		}
+
+		relation := &resourceRelation{
+			Dataset:     meta.ID,
+			ResID:       dr.ResID,
+			ForeignKeys: []*resourceForeignKey{},
+		}
+		for _, v := range dr.Variables {
+			resID, column := variables.ForeignKey(v)
+			if column != "" && datasetIDs[resID] != "" {
+				relation.ForeignKeys = append(relation.ForeignKeys, &resourceForeignKey{
+					Variable: v.Key,
+					Dataset:  datasetIDs[resID],
+					ResID:    resID,
+					Column:   column,
+				})
+			}
+			// the referenced resource is not part of the resource dataset
+			v.RefersTo = nil
+		}
+
+		resourceFolder := path.Join(resourcesFolder, dr.ResID)
+		err = os.MkdirAll(resourceFolder, os.ModePerm)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create resource folder")
+		}
+		err = writeResourceData(schemaPath, dr, resourceFolder)
+		if err != nil {
+			return nil, err
+		}
+
+		resourceMeta := *meta
+		resourceMeta.ID = datasetIDs[dr.ResID]
+		resourceMeta.StorageName = model.NormalizeDatasetID(resourceMeta.ID)
+		if dr != mainDR {
+			resourceMeta.Name = fmt.Sprintf("%s %s", meta.Name, dr.ResID)
+		}
+		resourceMeta.DataResources = []*model.DataResource{dr}
+
+		resourceSchema := path.Join(resourceFolder, compute.D3MDataSchema)
+		err = serialization.GetStorage(resourceSchema).WriteMetadata(resourceSchema, &resourceMeta, true, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to store schema of resource %s", dr.ResID)
+		}
+
+		if dr == mainDR {
+			classificationRelative := ingestConfig.ClassificationOutputPathRelative
+			err = copyClassification(path.Join(datasetFolder, classificationRelative), path.Join(resourceFolder, classificationRelative), len(dr.Variables))
+			if err != nil {
+				return nil, err
+			}
+			for _, relative := range []string{ingestConfig.RankingOutputPathRelative, ingestConfig.SummaryOutputPathRelative,
+				ingestConfig.SummaryMachineOutputPathRelative} {
+				err = copySideFile(path.Join(datasetFolder, relative), path.Join(resourceFolder, relative))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		resources = append(resources, &resourceDataset{
+			ResID:      dr.ResID,
+			DatasetID:  resourceMeta.ID,
+			SchemaPath: resourceSchema,
+			Relation:   relation,
+		})
+	}
+
+	return resources, nil
+}
+
+// writeResourceData points the resource at its data, writing a copy that
+// numbers the rows when the resource has no d3mIndex.
+func writeResourceData(schemaPath string, dr *model.DataResource, resourceFolder string) error {
+	dataPath := model.GetResourcePath(schemaPath, dr)
+	for _, v := range dr.Variables {
+		if v.Key == model.D3MIndexFieldName {
+			dr.ResPath = dataPath
+			return nil
+		}
+	}
+
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read resource %s", dr.ResID)
+	}
+	for i, line := range lines {
+		index := model.D3MIndexFieldName
+		if i > 0 {
+			index = strconv.Itoa(i - 1)
+		}
+		lines[i] = append([]string{index}, line...)
+	}
+	for _, v := range dr.Variables {
+		v.Index++
+	}
+	indexVariable := model.NewVariable(0, model.D3MIndexFieldName, model.D3MIndexFieldName, model.D3MIndexFieldName,
+		model.D3MIndexFieldName, model.IndexType, model.IndexType, "row index added on ingest", []string{model.RoleIndex},
+		model.VarDistilRoleIndex, nil, dr.Variables, false)
+	dr.Variables = append([]*model.Variable{indexVariable}, dr.Variables...)
+
+	outputData := path.Join(resourceFolder, compute.D3MDataFolder, path.Base(dataPath))
+	err = serialization.GetStorage(outputData).WriteData(outputData, lines)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write resource %s", dr.ResID)
+	}
+	dr.ResPath = outputData
+
+	return nil
+}
+
+// copyClassification copies the classification of the dataset when it has a
+// result for each of the variables.
+func copyClassification(source string, destination string, variableCount int) error {
+	b, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read classification file")
+	}
+	classification := &model.ClassificationData{}
+	err = json.Unmarshal(b, classification)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse classification file")
+	}
+	if len(classification.Labels) != variableCount {
+		return nil
+	}
+
+	err = os.MkdirAll(path.Dir(destination), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create classification output directory")
+	}
+	err = ioutil.WriteFile(destination, b, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store classification result")
+	}
+
+	return nil
+}
+
+// copySideFile copies an output stored next to the dataset schema, such as
+// the ranking or summary, when there is one.
+func copySideFile(source string, destination string) error {
+	b, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", source)
+	}
+
+	err = os.MkdirAll(path.Dir(destination), os.ModePerm)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create directory of %s", destination)
+	}
+	err = ioutil.WriteFile(destination, b, os.ModePerm)
+	if err != nil {
+		return errors.Wrapf(err, "unable to store %s", destination)
+	}
+
+	return nil
+}
+
+// recordRelation stores the source dataset and foreign keys of a resource
+// dataset on its dataset document.
+func recordRelation(config *env.Config, datasetID string, relation *resourceRelation) error {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			relationalField: relation,
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to record foreign keys in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
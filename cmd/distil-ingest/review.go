@@ -0,0 +1,86 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+const (
+	reviewVariableColumn = "variable"
+	reviewSelectedColumn = "selected_type"
+)
+
+// loadTypeReview reads the selected types from a type review written by
+// distil-classify as overrides. Rows with an empty selected type are left to
+// the classification.
+func loadTypeReview(filename string) ([]*typeOverride, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %s", filename)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", filename)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	variableIndex := -1
+	selectedIndex := -1
+	for i, c := range lines[0] {
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case reviewVariableColumn:
+			variableIndex = i
+		case reviewSelectedColumn:
+			selectedIndex = i
+		}
+	}
+	if variableIndex < 0 || selectedIndex < 0 {
+		return nil, errors.Errorf("type review %s needs %s and %s columns", filename, reviewVariableColumn, reviewSelectedColumn)
+	}
+
+	overrides := []*typeOverride{}
+	for i, line := range lines[1:] {
+		if len(line) <= variableIndex || len(line) <= selectedIndex {
+			continue
+		}
+		typ := strings.TrimSpace(line[selectedIndex])
+		if typ == "" {
+			continue
+		}
+		if model.MapTA2Type(typ) == "" {
+			return nil, errors.Errorf("type review row %d has unsupported type '%s'", i+1, typ)
+		}
+		overrides = append(overrides, &typeOverride{
+			Variable: line[variableIndex],
+			Type:     typ,
+		})
+	}
+
+	return overrides, nil
+}
@@ -0,0 +1,876 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	storage "github.com/uncharted-distil/distil/api/model/storage/elastic"
+	"github.com/uncharted-distil/distil/api/postgres"
+	"github.com/uncharted-distil/distil/api/task"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	resultTableSuffix  = "_result"
+	explainTableSuffix = "_explain"
+
+	snapshotVersion      = 1
+	snapshotManifestFile = "manifest.json"
+	snapshotDatasetFile  = "dataset.json"
+	snapshotModelFolder  = "models"
+	snapshotTableFolder  = "tables"
+	snapshotModelLimit   = 10000
+	// rows are stored one JSON array per line so lines can get long
+	snapshotMaxLineSize = 256 * 1024 * 1024
+)
+
+var (
+	// the tables backing a dataset, named by suffixing its storage name
+	snapshotTableSuffixes = []string{baseTableSuffix, variableTableSuffix, resultTableSuffix, explainTableSuffix}
+
+	indexNameRegex = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+("(?:[^"]|"")+"|\S+)\s+ON\s`)
+)
+
+// snapshotManifest describes the contents of a snapshot archive.
+type snapshotManifest struct {
+	Version     int              `json:"version"`
+	Created     time.Time        `json:"created"`
+	DatasetID   string           `json:"datasetId"`
+	StorageName string           `json:"storageName"`
+	Dataset     string           `json:"dataset"`
+	Models      []*snapshotModel `json:"models"`
+	Tables      []*snapshotTable `json:"tables"`
+	View        string           `json:"view,omitempty"`
+}
+
+// snapshotModel references a model document stored in the archive.
+type snapshotModel struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+}
+
+// snapshotTable references a postgres table dump stored in the archive.
+type snapshotTable struct {
+	Name    string            `json:"name"`
+	Suffix  string            `json:"suffix"`
+	File    string            `json:"file"`
+	Columns []*snapshotColumn `json:"columns"`
+	Indexes []string          `json:"indexes"`
+	Rows    int64             `json:"rows"`
+}
+
+// snapshotColumn is a table column along with its postgres type.
+type snapshotColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func snapshotCommand() cli.Command {
+	return cli.Command{
+		Name:      "snapshot",
+		Usage:     "Bundle the metadata, models and tables of an ingested dataset into an archive",
+		UsageText: "distil-ingest snapshot --dataset=<id> --output=<filepath> --es-endpoint=<url> --database=<name>",
+		Flags: append(storageFlags(),
+			cli.StringFlag{
+				Name:  "dataset",
+				Value: "",
+				Usage: "The id of the dataset to snapshot",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Value: "",
+				Usage: "The snapshot archive file path",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.String("dataset") == "" {
+				return cli.NewExitError("missing commandline flag `--dataset`", 1)
+			}
+			if c.String("output") == "" {
+				return cli.NewExitError("missing commandline flag `--output`", 1)
+			}
+			config, err := loadStorageConfig(c)
+			if err != nil {
+				return err
+			}
+
+			manifest, err := snapshotDataset(c.String("dataset"), filepath.Clean(c.String("output")), config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("snapshot of dataset %s written to %s (models: %d, tables: %d)",
+				manifest.DatasetID, c.String("output"), len(manifest.Models), len(manifest.Tables))
+
+			return nil
+		},
+	}
+}
+
+func restoreCommand() cli.Command {
+	return cli.Command{
+		Name:      "restore",
+		Usage:     "Load a dataset snapshot archive into Elasticsearch and postgres",
+		UsageText: "distil-ingest restore --input=<filepath> --es-endpoint=<url> --database=<name> [--dataset=<id>] [--prefix=<prefix>]",
+		Flags: append(storageFlags(),
+			cli.StringFlag{
+				Name:  "input",
+				Value: "",
+				Usage: "The snapshot archive file path",
+			},
+			cli.StringFlag{
+				Name:  "dataset",
+				Value: "",
+				Usage: "The id to restore the dataset under - defaults to the id in the snapshot",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Value: "",
+				Usage: "The prefix to add to the restored dataset and model ids",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "Replace the dataset if it already exists in the target",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.String("input") == "" {
+				return cli.NewExitError("missing commandline flag `--input`", 1)
+			}
+			config, err := loadStorageConfig(c)
+			if err != nil {
+				return err
+			}
+
+			datasetID, err := restoreDataset(filepath.Clean(c.String("input")), c.String("dataset"), c.String("prefix"), c.Bool("overwrite"), config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("restored dataset %s from %s", datasetID, c.String("input"))
+
+			return nil
+		},
+	}
+}
+
+func snapshotDataset(datasetID string, archivePath string, config *env.Config) (*snapshotManifest, error) {
+	ctx := context.Background()
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("reading metadata document for dataset %s", datasetID)
+	res, err := client.Get().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil, errors.Errorf("dataset '%s' not found in index '%s'", datasetID, config.ESDatasetsIndex)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to fetch dataset '%s'", datasetID)
+	}
+	source := map[string]interface{}{}
+	err = json.Unmarshal(res.Source, &source)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse dataset document")
+	}
+	storageName, _ := source["storageName"].(string)
+	if storageName == "" {
+		storageName = model.NormalizeDatasetID(datasetID)
+	}
+
+	manifest := &snapshotManifest{
+		Version:     snapshotVersion,
+		Created:     time.Now(),
+		DatasetID:   datasetID,
+		StorageName: storageName,
+		Dataset:     snapshotDatasetFile,
+	}
+
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create snapshot archive")
+	}
+	defer archive.Close()
+	gw := gzip.NewWriter(archive)
+	tw := tar.NewWriter(gw)
+
+	err = addArchiveFile(tw, snapshotDatasetFile, res.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	// the model index analyzes dataset ids so matches need to be checked exactly
+	log.Infof("reading model documents for dataset %s", datasetID)
+	modelRes, err := client.Search().
+		Index(config.ESModelsIndex).
+		Query(elastic.NewMatchPhraseQuery("datasetId", datasetID)).
+		Size(snapshotModelLimit).
+		Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "unable to fetch models for dataset '%s'", datasetID)
+	}
+	if modelRes != nil {
+		for _, hit := range modelRes.Hits.Hits {
+			modelSource := map[string]interface{}{}
+			err = json.Unmarshal(hit.Source, &modelSource)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to parse model document")
+			}
+			if modelSource["datasetId"] != datasetID {
+				continue
+			}
+			file := path.Join(snapshotModelFolder, fmt.Sprintf("%s.json", hit.Id))
+			err = addArchiveFile(tw, file, hit.Source)
+			if err != nil {
+				return nil, err
+			}
+			manifest.Models = append(manifest.Models, &snapshotModel{ID: hit.Id, File: file})
+		}
+	}
+
+	pg, err := newDatabase(task.NewConfig(*config))
+	if err != nil {
+		return nil, err
+	}
+	for _, suffix := range snapshotTableSuffixes {
+		tableName := fmt.Sprintf("%s%s", storageName, suffix)
+		exists, err := tableExists(pg, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			log.Warnf("table %s does not exist so it will not be included in the snapshot", tableName)
+			continue
+		}
+
+		log.Infof("dumping table %s", tableName)
+		table, err := dumpTable(pg, tw, tableName, suffix)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Tables = append(manifest.Tables, table)
+	}
+
+	err = pg.Client.QueryRow("SELECT pg_get_viewdef($1::regclass, true);", fmt.Sprintf("\"%s\"", storageName)).Scan(&manifest.View)
+	if err != nil {
+		log.Warnf("unable to read definition of view %s so it will not be included in the snapshot: %v", storageName, err)
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "	")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal snapshot manifest")
+	}
+	err = addArchiveFile(tw, snapshotManifestFile, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to finalize snapshot archive")
+	}
+	err = gw.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to finalize snapshot archive")
+	}
+
+	return manifest, nil
+}
+
+// dumpTable writes the rows of a table to the archive as JSON arrays of text
+// values, relying on postgres text casts to round trip every column type.
+func dumpTable(pg *postgres.Database, tw *tar.Writer, tableName string, suffix string) (*snapshotTable, error) {
+	columns, err := fetchColumns(pg, tableName)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := fetchIndexes(pg, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tar header needs the size up front so rows are staged on disk first
+	tmp, err := ioutil.TempFile("", "distil-snapshot")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temporary table dump")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	selects := make([]string, len(columns))
+	for i, c := range columns {
+		selects[i] = fmt.Sprintf("\"%s\"::text", c.Name)
+	}
+	rows, err := pg.Client.Query(fmt.Sprintf("SELECT %s FROM \"%s\";", strings.Join(selects, ", "), tableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read table %s", tableName)
+	}
+	defer rows.Close()
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	values := make([]*string, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	count := int64(0)
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse row of table %s", tableName)
+		}
+		err = encoder.Encode(values)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to write row of table %s", tableName)
+		}
+		count++
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read table %s", tableName)
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to write dump of table %s", tableName)
+	}
+
+	file := path.Join(snapshotTableFolder, fmt.Sprintf("%s.json", tableName))
+	err = addArchiveFileFrom(tw, file, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotTable{
+		Name:    tableName,
+		Suffix:  suffix,
+		File:    file,
+		Columns: columns,
+		Indexes: indexes,
+		Rows:    count,
+	}, nil
+}
+
+func fetchColumns(pg *postgres.Database, tableName string) ([]*snapshotColumn, error) {
+	sql := "SELECT a.attname, format_type(a.atttypid, a.atttypmod) FROM pg_attribute a " +
+		"WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum;"
+	rows, err := pg.Client.Query(sql, fmt.Sprintf("\"%s\"", tableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read columns of table %s", tableName)
+	}
+	defer rows.Close()
+
+	columns := []*snapshotColumn{}
+	for rows.Next() {
+		column := &snapshotColumn{}
+		err = rows.Scan(&column.Name, &column.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse columns of table %s", tableName)
+		}
+		columns = append(columns, column)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read columns of table %s", tableName)
+	}
+
+	return columns, nil
+}
+
+func fetchIndexes(pg *postgres.Database, tableName string) ([]string, error) {
+	rows, err := pg.Client.Query("SELECT indexdef FROM pg_indexes WHERE tablename = $1;", tableName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read indexes of table %s", tableName)
+	}
+	defer rows.Close()
+
+	indexes := []string{}
+	for rows.Next() {
+		var index string
+		err = rows.Scan(&index)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse indexes of table %s", tableName)
+		}
+		indexes = append(indexes, index)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read indexes of table %s", tableName)
+	}
+
+	return indexes, nil
+}
+
+func restoreDataset(archivePath string, datasetID string, prefix string, overwrite bool, config *env.Config) (string, error) {
+	folder, err := ioutil.TempDir("", "distil-restore")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create temporary restore folder")
+	}
+	defer os.RemoveAll(folder)
+
+	err = extractArchive(archivePath, folder)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := &snapshotManifest{}
+	err = readJSONFile(path.Join(folder, snapshotManifestFile), manifest)
+	if err != nil {
+		return "", err
+	}
+	if manifest.Version != snapshotVersion {
+		return "", errors.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+
+	if datasetID == "" {
+		datasetID = manifest.DatasetID
+	}
+	datasetID = prefix + datasetID
+	storageName := manifest.StorageName
+	if datasetID != manifest.DatasetID {
+		storageName = model.NormalizeDatasetID(datasetID)
+	}
+	// table, view and index names all embed the storage name
+	renamed := map[string]string{manifest.StorageName: storageName}
+	for _, suffix := range snapshotTableSuffixes {
+		renamed[manifest.StorageName+suffix] = storageName + suffix
+	}
+	for _, table := range manifest.Tables {
+		for _, index := range table.Indexes {
+			match := indexNameRegex.FindStringSubmatch(index)
+			if match == nil {
+				continue
+			}
+			name := unquoteIdentifier(match[1])
+			if strings.HasPrefix(name, manifest.StorageName) {
+				renamed[name] = storageName + strings.TrimPrefix(name, manifest.StorageName)
+			}
+		}
+	}
+	rename := func(s string) string {
+		if storageName == manifest.StorageName {
+			return s
+		}
+		return renameIdentifiers(s, renamed)
+	}
+
+	ctx := context.Background()
+	esClientCtor := es.NewClient(config.ElasticEndpoint, false)
+	_, err = storage.NewMetadataStorage(config.ESDatasetsIndex, true, esClientCtor)()
+	if err != nil {
+		return "", err
+	}
+	_, err = storage.NewExportedModelStorage(config.ESModelsIndex, true, esClientCtor)()
+	if err != nil {
+		return "", err
+	}
+	client, err := esClientCtor()
+	if err != nil {
+		return "", err
+	}
+
+	exists, err := client.Exists().Index(config.ESDatasetsIndex).Id(datasetID).Do(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to check for existing dataset '%s'", datasetID)
+	}
+	if exists && !overwrite {
+		return "", errors.Errorf("dataset '%s' already exists in index '%s'", datasetID, config.ESDatasetsIndex)
+	}
+
+	pg, err := newDatabase(task.NewConfig(*config))
+	if err != nil {
+		return "", err
+	}
+	names := []string{storageName}
+	for _, table := range manifest.Tables {
+		names = append(names, fmt.Sprintf("%s%s", storageName, table.Suffix))
+	}
+	for _, name := range names {
+		exists, err = tableExists(pg, name)
+		if err != nil {
+			return "", err
+		}
+		if exists && !overwrite {
+			return "", errors.Errorf("table %s already exists", name)
+		}
+	}
+
+	tx, err := pg.Client.Begin()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to start restore transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	if overwrite {
+		_, err = tx.Exec(ctx, fmt.Sprintf("DROP VIEW IF EXISTS \"%s\";", storageName))
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to drop view %s", storageName)
+		}
+		for _, name := range names[1:] {
+			_, err = tx.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS \"%s\";", name))
+			if err != nil {
+				return "", errors.Wrapf(err, "unable to drop table %s", name)
+			}
+		}
+	}
+
+	batchSize := config.PostgresBatchSize
+	for _, table := range manifest.Tables {
+		tableName := fmt.Sprintf("%s%s", storageName, table.Suffix)
+		log.Infof("restoring table %s (%d rows)", tableName, table.Rows)
+
+		definitions := make([]string, len(table.Columns))
+		for i, c := range table.Columns {
+			definitions[i] = fmt.Sprintf("\"%s\" %s", c.Name, c.Type)
+		}
+		_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TABLE \"%s\" (%s);", tableName, strings.Join(definitions, ", ")))
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to create table %s", tableName)
+		}
+
+		err = restoreRows(ctx, tx, path.Join(folder, table.File), tableName, table.Columns, batchSize)
+		if err != nil {
+			return "", err
+		}
+
+		for _, index := range table.Indexes {
+			_, err = tx.Exec(ctx, rename(index))
+			if err != nil {
+				return "", errors.Wrapf(err, "unable to create index on table %s", tableName)
+			}
+		}
+	}
+
+	if manifest.View != "" {
+		_, err = tx.Exec(ctx, fmt.Sprintf("CREATE VIEW \"%s\" AS %s", storageName, rename(manifest.View)))
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to create view %s", storageName)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to commit restore")
+	}
+
+	log.Infof("restoring metadata document for dataset %s", datasetID)
+	source := map[string]interface{}{}
+	err = readJSONFile(path.Join(folder, manifest.Dataset), &source)
+	if err != nil {
+		return "", err
+	}
+	source["datasetID"] = datasetID
+	source["storageName"] = storageName
+	_, err = client.Index().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		BodyJson(source).
+		Refresh("true").
+		Do(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to add document to index `%s`", config.ESDatasetsIndex)
+	}
+
+	for _, m := range manifest.Models {
+		// models restored under a new dataset id need new ids so the source
+		// models are left alone
+		modelID := prefix + m.ID
+		if prefix == "" && datasetID != manifest.DatasetID {
+			modelID = fmt.Sprintf("%s-%s", datasetID, m.ID)
+		}
+		modelSource := map[string]interface{}{}
+		err = readJSONFile(path.Join(folder, m.File), &modelSource)
+		if err != nil {
+			return "", err
+		}
+		modelSource["datasetId"] = datasetID
+		_, err = client.Index().
+			Index(config.ESModelsIndex).
+			Id(modelID).
+			BodyJson(modelSource).
+			Refresh("true").
+			Do(ctx)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to add document to index `%s`", config.ESModelsIndex)
+		}
+	}
+
+	return datasetID, nil
+}
+
+// renameIdentifiers replaces the identifiers of a view definition or index
+// statement found in the renamed map, whether quoted or not. String literals
+// and identifiers that only contain a renamed name, such as column names, are
+// left alone.
+func renameIdentifiers(sql string, renamed map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			// quotes are escaped by doubling them
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					j++
+					break
+				}
+				j++
+			}
+			text := sql[i:j]
+			if name, ok := renamed[unquoteIdentifier(text)]; ok && c == '"' {
+				text = quoteIdentifier(name)
+			}
+			b.WriteString(text)
+			i = j
+		case isIdentifierByte(c):
+			j := i + 1
+			for j < len(sql) && isIdentifierByte(sql[j]) {
+				j++
+			}
+			// unquoted identifiers are folded to lower case, and words
+			// starting with a digit are numbers
+			text := sql[i:j]
+			if name, ok := renamed[strings.ToLower(text)]; ok && (c < '0' || c > '9') {
+				text = quoteIdentifier(name)
+			}
+			b.WriteString(text)
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func quoteIdentifier(name string) string {
+	return fmt.Sprintf("\"%s\"", strings.Replace(name, "\"", "\"\"", -1))
+}
+
+func unquoteIdentifier(name string) string {
+	if len(name) < 2 || name[0] != '"' || name[len(name)-1] != '"' {
+		return name
+	}
+	return strings.Replace(name[1:len(name)-1], "\"\"", "\"", -1)
+}
+
+// restoreRows inserts the dumped rows of a table, casting the text values
+// back to their column types.
+func restoreRows(ctx context.Context, tx pgx.Tx, filename string, tableName string, columns []*snapshotColumn, batchSize int) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open dump of table %s", tableName)
+	}
+	defer file.Close()
+
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = fmt.Sprintf("\"%s\"", c.Name)
+	}
+	if batchSize <= 0 || batchSize*len(columns) > maxStatementParams {
+		batchSize = maxStatementParams / len(columns)
+	}
+
+	insert := func(rows [][]*string) error {
+		params := make([]interface{}, 0, len(rows)*len(columns))
+		values := make([]string, len(rows))
+		for i, row := range rows {
+			if len(row) != len(columns) {
+				return errors.Errorf("row of table %s has %d values but %d columns", tableName, len(row), len(columns))
+			}
+			placeholders := make([]string, len(columns))
+			for j, c := range columns {
+				params = append(params, row[j])
+				placeholders[j] = fmt.Sprintf("$%d::text::%s", len(params), c.Type)
+			}
+			values[i] = fmt.Sprintf("(%s)", strings.Join(placeholders, ", "))
+		}
+		_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO \"%s\" (%s) VALUES %s;", tableName, strings.Join(names, ", "), strings.Join(values, ", ")), params...)
+		if err != nil {
+			return errors.Wrapf(err, "unable to insert rows into table %s", tableName)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), snapshotMaxLineSize)
+	batch := make([][]*string, 0, batchSize)
+	for scanner.Scan() {
+		row := []*string{}
+		err = json.Unmarshal(scanner.Bytes(), &row)
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse dump of table %s", tableName)
+		}
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			err = insert(batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return errors.Wrapf(err, "unable to read dump of table %s", tableName)
+	}
+	if len(batch) > 0 {
+		return insert(batch)
+	}
+
+	return nil
+}
+
+func addArchiveFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+
+	return nil
+}
+
+func addArchiveFileFrom(tw *tar.Writer, name string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+	_, err = io.Copy(tw, file)
+	if err != nil {
+		return errors.Wrapf(err, "unable to add %s to archive", name)
+	}
+
+	return nil
+}
+
+func extractArchive(archivePath string, folder string) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "unable to open snapshot archive")
+	}
+	defer archive.Close()
+	gr, err := gzip.NewReader(archive)
+	if err != nil {
+		return errors.Wrap(err, "unable to read snapshot archive")
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read snapshot archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// reject entries that would land outside of the restore folder
+		target := filepath.Join(folder, filepath.Clean(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(folder)+string(os.PathSeparator)) {
+			return errors.Errorf("invalid archive entry '%s'", header.Name)
+		}
+		err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		if err != nil {
+			return errors.Wrapf(err, "unable to extract %s", header.Name)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return errors.Wrapf(err, "unable to extract %s", header.Name)
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "unable to extract %s", header.Name)
+		}
+	}
+
+	return nil
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", filename)
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse %s", filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRenameIdentifiers(t *testing.T) {
+	renamed := map[string]string{
+		"d_iris":           "d_copy",
+		"d_iris_base":      "d_copy_base",
+		"d_iris_variable":  "d_copy_variable",
+		"d_iris_base_pkey": "d_copy_base_pkey",
+	}
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{"unquoted table", `SELECT base."d3mIndex" FROM d_iris_base base`, `SELECT base."d3mIndex" FROM "d_copy_base" base`},
+		{"quoted table", `SELECT * FROM "d_iris_base"`, `SELECT * FROM "d_copy_base"`},
+		{"schema qualified", `SELECT * FROM public.d_iris_base`, `SELECT * FROM public."d_copy_base"`},
+		{"column holding the name", `SELECT d_iris_base.d_iris_count, "d_iris_base_x" FROM d_iris_base`,
+			`SELECT "d_copy_base".d_iris_count, "d_iris_base_x" FROM "d_copy_base"`},
+		{"string literal", `SELECT 'd_iris_base' FROM d_iris_base`, `SELECT 'd_iris_base' FROM "d_copy_base"`},
+		{"escaped quotes", `SELECT 'it''s d_iris', "a""d_iris" FROM d_iris`, `SELECT 'it''s d_iris', "a""d_iris" FROM "d_copy"`},
+		{"upper case", `SELECT * FROM D_IRIS_BASE`, `SELECT * FROM "d_copy_base"`},
+		{"index", `CREATE UNIQUE INDEX d_iris_base_pkey ON public.d_iris_base USING btree ("d3mIndex")`,
+			`CREATE UNIQUE INDEX "d_copy_base_pkey" ON public."d_copy_base" USING btree ("d3mIndex")`},
+		{"numbers", `SELECT 1d_iris FROM d_iris`, `SELECT 1d_iris FROM "d_copy"`},
+	}
+	for _, test := range tests {
+		if actual := renameIdentifiers(test.sql, renamed); actual != test.expected {
+			t.Errorf("%s: renameIdentifiers(%q) = %q, expected %q", test.name, test.sql, actual, test.expected)
+		}
+	}
+}
+
+func TestIndexNameRegex(t *testing.T) {
+	tests := []struct {
+		index    string
+		expected string
+	}{
+		{`CREATE INDEX d_iris_base_idx ON public.d_iris_base USING btree (a)`, "d_iris_base_idx"},
+		{`CREATE UNIQUE INDEX "d_iris ""base"" idx" ON public.d_iris_base USING btree (a)`, `d_iris "base" idx`},
+		{`ALTER TABLE d_iris_base ADD COLUMN a text`, ""},
+	}
+	for _, test := range tests {
+		actual := ""
+		if match := indexNameRegex.FindStringSubmatch(test.index); match != nil {
+			actual = unquoteIdentifier(match[1])
+		}
+		if actual != test.expected {
+			t.Errorf("index name of %q = %q, expected %q", test.index, actual, test.expected)
+		}
+	}
+}
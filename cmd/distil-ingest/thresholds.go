@@ -0,0 +1,256 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/classifier"
+	"github.com/uncharted-distil/distil-ingest/pkg/configfile"
+	es "github.com/uncharted-distil/distil/api/elastic"
+	"github.com/uncharted-distil/distil/api/env"
+	api "github.com/uncharted-distil/distil/api/model"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// unknownFallbacksField holds the variables reset to unknown by the
+	// thresholds on the dataset document so they survive a recompute of the
+	// suggested types.
+	unknownFallbacksField = "unknownFallbacks"
+	// unknownCandidateCount is the number of candidate types listed for each
+	// variable that fell back to unknown.
+	unknownCandidateCount = 3
+)
+
+// typeRules holds the user supplied rules applied to the selected types once
+// the suggested types have been verified.
+type typeRules struct {
+	Thresholds        map[string]float64
+	DefaultThreshold  float64
+	UnknownReportPath string
+	Overrides         []*typeOverride
+}
+
+// typeThresholds is the content of a type thresholds file.
+type typeThresholds struct {
+	Thresholds map[string]float64 `json:"thresholds" yaml:"thresholds"`
+}
+
+// unknownFallback is a variable whose selected type was not probable enough
+// to be accepted.
+type unknownFallback struct {
+	VariableKey string
+	Type        string
+	Probability float64
+	Threshold   float64
+	Candidates  []*model.SuggestedType
+}
+
+// loadTypeThresholds reads the per type thresholds from the optional JSON or
+// YAML file and then from the type=threshold entries, with the entries taking
+// precedence.
+func loadTypeThresholds(filename string, entries []string) (map[string]float64, error) {
+	thresholds := map[string]float64{}
+	if filename != "" {
+		parsed := &typeThresholds{}
+		err := configfile.Read(filename, parsed)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load type thresholds")
+		}
+		for typ, threshold := range parsed.Thresholds {
+			thresholds[typ] = threshold
+		}
+	}
+
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("type threshold '%s' is not of the form type=threshold", entry)
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse type threshold '%s'", entry)
+		}
+		thresholds[strings.TrimSpace(fields[0])] = threshold
+	}
+
+	for typ := range thresholds {
+		if model.MapTA2Type(typ) == "" {
+			return nil, errors.Errorf("type threshold set for unsupported type '%s'", typ)
+		}
+	}
+
+	return thresholds, nil
+}
+
+// applyTypeThresholds resets the type of every variable whose selected type
+// was suggested by the classifier with a probability below the threshold of
+// that type to unknown. Types without a threshold use the default threshold,
+// which is zero when not set so they are left alone.
+func applyTypeThresholds(datasetID string, thresholds map[string]float64, defaultThreshold float64,
+	storage api.MetadataStorage, dataStorage api.DataStorage) ([]*unknownFallback, error) {
+	ds, err := storage.FetchDataset(datasetID, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if ds == nil {
+		return nil, errors.Errorf("dataset '%s' not found", datasetID)
+	}
+
+	fallbacks := []*unknownFallback{}
+	for _, v := range ds.Variables {
+		if model.IsIndexRole(v.SelectedRole) || v.IsGrouping() || v.Type == model.UnknownType {
+			continue
+		}
+
+		// only types picked by the classifier are checked, against the
+		// probability the classifier gave them
+		candidates := []*model.SuggestedType{}
+		probability := -1.0
+		for _, t := range v.SuggestedTypes {
+			if t.Provenance != metadata.ProvenanceSimon {
+				continue
+			}
+			candidate := &model.SuggestedType{
+				Type:        t.Type,
+				Probability: classifiedProbability(t),
+				Provenance:  t.Provenance,
+			}
+			candidates = append(candidates, candidate)
+			if t.Type == v.Type && candidate.Probability > probability {
+				probability = candidate.Probability
+			}
+		}
+		if probability < 0 {
+			continue
+		}
+
+		threshold, ok := thresholds[v.Type]
+		if !ok {
+			threshold = defaultThreshold
+		}
+		if probability >= threshold {
+			continue
+		}
+
+		log.Warnf("variable %s type %s has probability %.2f below threshold %.2f so defaulting to %s",
+			v.Key, v.Type, probability, threshold, model.UnknownType)
+		err = dataStorage.SetDataType(ds.ID, ds.StorageName, v.Key, model.UnknownType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to reset type of variable %s", v.Key)
+		}
+		err = storage.SetDataType(ds.ID, v.Key, model.UnknownType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to reset type of variable %s", v.Key)
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Probability > candidates[j].Probability
+		})
+		if len(candidates) > unknownCandidateCount {
+			candidates = candidates[:unknownCandidateCount]
+		}
+		fallbacks = append(fallbacks, &unknownFallback{
+			VariableKey: v.Key,
+			Type:        v.Type,
+			Probability: probability,
+			Threshold:   threshold,
+			Candidates:  candidates,
+		})
+	}
+
+	return fallbacks, nil
+}
+
+// recordUnknownFallbacks stores the variables reset to unknown by the
+// thresholds on the dataset document.
+func recordUnknownFallbacks(config *env.Config, datasetID string, fallbacks []*unknownFallback) error {
+	client, err := es.NewClient(config.ElasticEndpoint, false)()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(fallbacks))
+	for i, fallback := range fallbacks {
+		keys[i] = fallback.VariableKey
+	}
+	_, err = client.Update().
+		Index(config.ESDatasetsIndex).
+		Id(datasetID).
+		Doc(map[string]interface{}{
+			unknownFallbacksField: keys,
+		}).
+		Refresh("true").
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to record unknown fallbacks in index `%s`", config.ESDatasetsIndex)
+	}
+
+	return nil
+}
+
+// classifiedProbability undoes the weighting of non basic types applied when
+// the classification is loaded. The weighting is decided on the classifier
+// label before it is mapped to a distil type, so integers, labelled int by the
+// classifier, are weighted even though the integer type is basic.
+func classifiedProbability(t *model.SuggestedType) float64 {
+	if t.Type != model.IntegerType && model.IsBasicSimonType(t.Type) {
+		return t.Probability
+	}
+	return t.Probability / classifier.NonBasicTypeWeight
+}
+
+// writeUnknownReport writes the variables that fell back to unknown to a CSV
+// file along with their most probable candidate types.
+func writeUnknownReport(filename string, fallbacks []*unknownFallback) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", filename)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"variable", "type", "probability", "threshold", "candidates"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write unknown type report")
+	}
+	for _, fallback := range fallbacks {
+		err = writer.Write([]string{
+			fallback.VariableKey,
+			fallback.Type,
+			fmt.Sprintf("%.4f", fallback.Probability),
+			fmt.Sprintf("%.4f", fallback.Threshold),
+			formatSuggestedTypes(fallback.Candidates),
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to write unknown type report")
+		}
+	}
+	writer.Flush()
+
+	return errors.Wrap(writer.Error(), "unable to write unknown type report")
+}
@@ -0,0 +1,86 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestClassifiedProbability(t *testing.T) {
+	folder, err := ioutil.TempDir("", "thresholds-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	dataPath := path.Join(folder, "learningData.csv")
+	err = ioutil.WriteFile(dataPath, []byte("count,city,score\n1,Toronto,0.5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classificationPath := path.Join(folder, "classification.json")
+	err = ioutil.WriteFile(classificationPath, []byte(`{
+		"labels": [["int", "categorical"], ["categorical", "string"], ["real"]],
+		"label_probabilities": [[0.6, 0.3], [0.6, 0.4], [0.7]]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := metadata.LoadMetadataFromRawFile(dataPath, classificationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key      string
+		typ      string
+		expected float64
+	}{
+		{"count", model.IntegerType, 0.6},
+		{"count", model.CategoricalType, 0.3},
+		{"city", model.CategoricalType, 0.6},
+		{"city", model.StringType, 0.4},
+		{"score", model.RealType, 0.7},
+	}
+	for _, test := range tests {
+		var suggested *model.SuggestedType
+		for _, v := range meta.DataResources[0].Variables {
+			if v.Key != test.key {
+				continue
+			}
+			for _, typ := range v.SuggestedTypes {
+				if typ.Type == test.typ {
+					suggested = typ
+				}
+			}
+		}
+		if suggested == nil {
+			t.Errorf("%s has no suggested type %s", test.key, test.typ)
+			continue
+		}
+		if actual := classifiedProbability(suggested); math.Abs(actual-test.expected) > 1e-9 {
+			t.Errorf("classifiedProbability(%s %s) = %v, expected %v", test.key, test.typ, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil/api/postgres"
+	"github.com/uncharted-distil/distil/api/serialization"
+	"github.com/uncharted-distil/distil/api/task"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	baseTableSuffix     = "_base"
+	variableTableSuffix = "_variable"
+	stageTableSuffix    = "_stage"
+	// postgres caps the number of bind parameters in a single statement
+	maxStatementParams = 65535
+)
+
+// upsertResult captures the outcome of merging staged rows into an existing
+// dataset table.
+type upsertResult struct {
+	Inserted  int64
+	Updated   int64
+	Unchanged int64
+}
+
+func newDatabase(ingestConfig *task.IngestTaskConfig) (*postgres.Database, error) {
+	postgresConfig := &postgres.Config{
+		Password:         ingestConfig.DatabasePassword,
+		User:             ingestConfig.DatabaseUser,
+		Database:         ingestConfig.Database,
+		Host:             ingestConfig.DatabaseHost,
+		Port:             ingestConfig.DatabasePort,
+		BatchSize:        ingestConfig.DatabaseBatchSize,
+		PostgresLogLevel: "error",
+	}
+	pg, err := postgres.NewDatabase(postgresConfig, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to initialize a new database")
+	}
+
+	return pg, nil
+}
+
+func tableExists(pg *postgres.Database, tableName string) (bool, error) {
+	var count int
+	err := pg.Client.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_name = $1;", tableName).Scan(&count)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to check for existence of table %s", tableName)
+	}
+
+	return count > 0, nil
+}
+
+func fetchStoredTypes(pg *postgres.Database, storageName string) (map[string]string, error) {
+	rows, err := pg.Client.Query(fmt.Sprintf("SELECT name, type FROM %s%s;", storageName, variableTableSuffix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read variable types for %s", storageName)
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var name, typ string
+		err = rows.Scan(&name, &typ)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse variable types for %s", storageName)
+		}
+		types[name] = typ
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read variable types for %s", storageName)
+	}
+
+	return types, nil
+}
+
+// loadUpsertMetadata loads the metadata the same way the ingest task does so
+// that the variable keys and ordering line up with the existing table.
+func loadUpsertMetadata(schemaPath string, config *task.IngestTaskConfig) (*model.Metadata, error) {
+	classificationPath := path.Join(path.Dir(schemaPath), config.ClassificationOutputPathRelative)
+	meta, err := metadata.LoadMetadataFromClassification(schemaPath, classificationPath, true, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load schema file")
+	}
+
+	return meta, nil
+}
+
+func findKeyVariable(variables []*model.Variable, key string) *model.Variable {
+	for _, v := range variables {
+		if v.Key == key || v.HeaderName == key {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// upsertPostgres stages the dataset rows and merges them into the existing
+// dataset table, updating rows that share the key and inserting the rest. It
+// returns false if there is no existing table to merge into.
+func upsertPostgres(schemaPath string, key string, ingestConfig *task.IngestTaskConfig) (*upsertResult, bool, error) {
+	meta, err := loadUpsertMetadata(schemaPath, ingestConfig)
+	if err != nil {
+		return nil, false, err
+	}
+	variables := meta.GetMainDataResource().Variables
+	if key == "" {
+		key = model.D3MIndexName
+	}
+	keyVariable := findKeyVariable(variables, key)
+	if keyVariable == nil {
+		return nil, false, errors.Errorf("key variable '%s' not found in dataset", key)
+	}
+
+	pg, err := newDatabase(ingestConfig)
+	if err != nil {
+		return nil, false, err
+	}
+
+	baseTable := fmt.Sprintf("%s%s", meta.StorageName, baseTableSuffix)
+	exists, err := tableExists(pg, baseTable)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		log.Infof("no existing table %s to upsert into", baseTable)
+		return nil, false, nil
+	}
+
+	storedTypes, err := fetchStoredTypes(pg, meta.StorageName)
+	if err != nil {
+		return nil, false, err
+	}
+	columns := make([]string, len(variables))
+	for i, v := range variables {
+		if _, ok := storedTypes[v.Key]; !ok {
+			return nil, false, errors.Errorf("variable '%s' does not exist in table %s", v.Key, baseTable)
+		}
+		columns[i] = fmt.Sprintf("\"%s\"", v.Key)
+	}
+
+	dataPath := model.GetResourcePath(schemaPath, meta.GetMainDataResource())
+	log.Infof("staging rows for upsert from %s", dataPath)
+	data, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to read input data")
+	}
+	// skip header
+	data = data[1:]
+
+	ctx := context.Background()
+	tx, err := pg.Client.Begin()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to start upsert transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	stageTable := fmt.Sprintf("%s%s", meta.StorageName, stageTableSuffix)
+	_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s) ON COMMIT DROP;", stageTable, baseTable))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to create staging table")
+	}
+
+	batchSize := ingestConfig.DatabaseBatchSize
+	if batchSize <= 0 || batchSize*len(columns) > maxStatementParams {
+		batchSize = maxStatementParams / len(columns)
+	}
+	for start := 0; start < len(data); start += batchSize {
+		end := start + batchSize
+		if end > len(data) {
+			end = len(data)
+		}
+		// the header is the first line of the data
+		statement, params, err := buildStageInsert(stageTable, columns, variables, storedTypes, data[start:end], start+2)
+		if err != nil {
+			return nil, false, err
+		}
+		_, err = tx.Exec(ctx, statement, params...)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "unable to stage rows")
+		}
+	}
+
+	keyColumn := fmt.Sprintf("\"%s\"", keyVariable.Key)
+	var duplicates int64
+	err = tx.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM (SELECT %s FROM %s GROUP BY %s HAVING COUNT(*) > 1) d;",
+		keyColumn, stageTable, keyColumn)).Scan(&duplicates)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to check staged keys")
+	}
+	if duplicates > 0 {
+		return nil, false, errors.Errorf("%d values of key '%s' appear more than once in the incoming data", duplicates, keyVariable.Key)
+	}
+
+	assignments := make([]string, len(columns))
+	baseColumns := make([]string, len(columns))
+	stageColumns := make([]string, len(columns))
+	for i, c := range columns {
+		assignments[i] = fmt.Sprintf("%s = s.%s", c, c)
+		baseColumns[i] = fmt.Sprintf("b.%s", c)
+		stageColumns[i] = fmt.Sprintf("s.%s", c)
+	}
+
+	// only touch rows whose values actually differ so unchanged rows can be reported
+	update := fmt.Sprintf("UPDATE %s b SET %s FROM %s s WHERE b.%s = s.%s AND (%s) IS DISTINCT FROM (%s);",
+		baseTable, strings.Join(assignments, ", "), stageTable, keyColumn, keyColumn,
+		strings.Join(baseColumns, ", "), strings.Join(stageColumns, ", "))
+	tag, err := tx.Exec(ctx, update)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to update existing rows")
+	}
+	updated := tag.RowsAffected()
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s s WHERE NOT EXISTS (SELECT 1 FROM %s b WHERE b.%s = s.%s);",
+		baseTable, strings.Join(columns, ", "), strings.Join(stageColumns, ", "), stageTable, baseTable, keyColumn, keyColumn)
+	tag, err = tx.Exec(ctx, insert)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to insert new rows")
+	}
+	inserted := tag.RowsAffected()
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to commit upsert")
+	}
+
+	_, err = pg.Client.Exec(fmt.Sprintf("ANALYZE \"%s\"", baseTable))
+	if err != nil {
+		log.Warnf("error updating stats for %s: %+v", baseTable, err)
+	}
+
+	return &upsertResult{
+		Inserted:  inserted,
+		Updated:   updated,
+		Unchanged: int64(len(data)) - inserted - updated,
+	}, true, nil
+}
+
+// buildStageInsert builds the statement inserting the rows into the staging
+// table, numbering the rows in errors from the line of the first row.
+func buildStageInsert(tableName string, columns []string, variables []*model.Variable, types map[string]string, rows [][]string, firstLine int) (string, []interface{}, error) {
+	params := make([]interface{}, 0, len(rows)*len(columns))
+	values := make([]string, len(rows))
+	for i, row := range rows {
+		if len(row) < len(variables) {
+			return "", nil, errors.Errorf("line %d has %d values but the dataset has %d variables", firstLine+i, len(row), len(variables))
+		}
+		placeholders := make([]string, len(columns))
+		for j, v := range variables {
+			params = append(params, stageValue(types[v.Key], row[j]))
+			placeholders[j] = fmt.Sprintf("$%d", len(params))
+			if types[v.Key] == model.GeoBoundsType {
+				// geometries are parsed from their text the same way ingest does
+				placeholders[j] = fmt.Sprintf("%s::geometry", placeholders[j])
+			}
+		}
+		values[i] = fmt.Sprintf("(%s)", strings.Join(placeholders, ", "))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;", tableName, strings.Join(columns, ", "), strings.Join(values, ", ")), params, nil
+}
+
+// stageValue mirrors the value handling applied when rows are first ingested.
+func stageValue(typ string, value string) interface{} {
+	switch {
+	case value == "" && (typ == model.IndexType || typ == model.IntegerType || typ == model.RealType || typ == "float"):
+		return nil
+	case strings.HasSuffix(typ, "Vector") && !(strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}")):
+		return fmt.Sprintf("{%s}", value)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,83 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestBuildStageInsert(t *testing.T) {
+	variables := []*model.Variable{{Key: "d3mIndex"}, {Key: "bounds"}, {Key: "count"}, {Key: "embedding"}}
+	columns := []string{`"d3mIndex"`, `"bounds"`, `"count"`, `"embedding"`}
+	types := map[string]string{
+		"d3mIndex":  model.IndexType,
+		"bounds":    model.GeoBoundsType,
+		"count":     model.IntegerType,
+		"embedding": model.RealVectorType,
+	}
+
+	statement, params, err := buildStageInsert("stage", columns, variables, types, [][]string{
+		{"0", "POLYGON((0 0, 0 1, 1 1, 0 0))", "", "1,2"},
+		{"1", "POLYGON((0 0, 0 2, 2 2, 0 0))", "3", "{3,4}", "extra"},
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `INSERT INTO stage ("d3mIndex", "bounds", "count", "embedding") VALUES ` +
+		`($1, $2::geometry, $3, $4), ($5, $6::geometry, $7, $8);`
+	if statement != expected {
+		t.Errorf("buildStageInsert statement = %s, expected %s", statement, expected)
+	}
+	expectedParams := []interface{}{
+		"0", "POLYGON((0 0, 0 1, 1 1, 0 0))", nil, "{1,2}",
+		"1", "POLYGON((0 0, 0 2, 2 2, 0 0))", "3", "{3,4}",
+	}
+	if !reflect.DeepEqual(params, expectedParams) {
+		t.Errorf("buildStageInsert params = %v, expected %v", params, expectedParams)
+	}
+
+	_, _, err = buildStageInsert("stage", columns, variables, types, [][]string{
+		{"0", "POLYGON((0 0, 0 1, 1 1, 0 0))", "1", "{1}"},
+		{"1", "POLYGON((0 0, 0 2, 2 2, 0 0))"},
+	}, 2)
+	if err == nil || err.Error() != "line 3 has 2 values but the dataset has 4 variables" {
+		t.Errorf("buildStageInsert with a short row returned %v", err)
+	}
+}
+
+func TestStageValue(t *testing.T) {
+	tests := []struct {
+		typ      string
+		value    string
+		expected interface{}
+	}{
+		{model.IntegerType, "", nil},
+		{model.RealType, "", nil},
+		{model.IndexType, "", nil},
+		{model.StringType, "", ""},
+		{model.RealVectorType, "1,2", "{1,2}"},
+		{model.RealVectorType, "{1,2}", "{1,2}"},
+		{model.GeoBoundsType, "POINT(0 0)", "POINT(0 0)"},
+	}
+	for _, test := range tests {
+		if actual := stageValue(test.typ, test.value); actual != test.expected {
+			t.Errorf("stageValue(%s, %q) = %v, expected %v", test.typ, test.value, actual, test.expected)
+		}
+	}
+}
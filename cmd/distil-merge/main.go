@@ -16,6 +16,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
@@ -25,6 +26,7 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -38,7 +40,7 @@ func main() {
 	app.Name = "distil-merge"
 	app.Version = "0.1.0"
 	app.Usage = "Merge D3M training datasets"
-	app.UsageText = "distil-merge --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-merge --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath> [--report=<filepath>] [--strict] [--output-format=csv|parquet]"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "schema",
@@ -65,6 +67,25 @@ func main() {
 			Value: "",
 			Usage: "The merged output folder",
 		},
+		cli.StringFlag{
+			Name:  "report",
+			Value: "",
+			Usage: "The join report path, defaulting to mergeReport.json next to the schema",
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Fail before merging when a foreign key match rate is below the minimum",
+		},
+		cli.Float64Flag{
+			Name:  "min-match-rate",
+			Value: 0.95,
+			Usage: "The minimum share of main resource rows each foreign key must match in strict mode",
+		},
+		cli.StringFlag{
+			Name:  "output-format",
+			Value: "csv",
+			Usage: "The format of the merged data, either csv or parquet",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 
@@ -77,6 +98,9 @@ func main() {
 		if c.String("output") == "" {
 			return cli.NewExitError("missing commandline flag `--output`", 1)
 		}
+		if c.String("output-format") != "csv" && c.String("output-format") != "parquet" {
+			return cli.NewExitError(fmt.Sprintf("unsupported output format `%s`", c.String("output-format")), 1)
+		}
 
 		output := filepath.Clean(c.String("output"))
 		endpoint := filepath.Clean(c.String("endpoint"))
@@ -84,47 +108,115 @@ func main() {
 		schema := filepath.Clean(c.String("schema"))
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schema, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schema, dataset = working.SchemaPath, working.DataPath
+
+		// the report is written straight to the source dataset so it is kept
+		// when a strict merge fails and the working copy is removed
+		reportPath := c.String("report")
+		if reportPath == "" {
+			reportPath = working.SourcePath(path.Join(path.Dir(schema), mergeReportFile))
+		}
 
-		err = env.Initialize(&config)
+		// check the joins before the merge replaces the main resource
+		report, err := checkJoins(schema)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		ingestConfig := task.NewConfig(config)
+		for _, j := range report.Joins {
+			log.Infof("foreign key %s of resource %s to resource %s matched %d of %d rows (%.2f%%), fanning out %d rows",
+				j.Variable, j.SourceResID, j.ResID, j.Matched, j.Matched+j.Unmatched, j.MatchRate*100, j.FannedOut)
+		}
+		if c.Bool("strict") {
+			failed := report.failedJoins(c.Float64("min-match-rate"))
+			if len(failed) > 0 {
+				err = writeJoinReport(reportPath, report)
+				if err != nil {
+					log.Errorf("%v", err)
+					return cli.NewExitError(errors.Cause(err), 2)
+				}
+				err = errors.Errorf("foreign key %s of resource %s to resource %s matched %.2f%% of rows, below the minimum of %.2f%%",
+					failed[0].Variable, failed[0].SourceResID, failed[0].ResID, failed[0].MatchRate*100, c.Float64("min-match-rate")*100)
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+		}
 
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
+		mergedPath, err := mergeRemote(endpoint, dataset, schema, input, output)
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
 
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+		err = report.addMerged(mergedPath)
+		if err == nil {
+			err = writeJoinReport(reportPath, report)
+		}
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		defer client.Close()
-		task.SetClient(client)
+		log.Infof("Merged %d rows into %d, dropping %d and fanning out %d", report.MainRows, report.MergedRows, report.RowsDropped, report.RowsFannedOut)
+		log.Infof("Join report written to %s", reportPath)
+		if c.String("output-format") == "parquet" {
+			parquetPath, err := parquet.WriteOutput(mergedPath)
+			if err != nil {
+				log.Errorf("%v", err)
+				return cli.NewExitError(errors.Cause(err), 2)
+			}
+			log.Infof("Parquet data written to %s", working.SourcePath(parquetPath))
+		}
+		log.Infof("Merged data written to %s", working.SourcePath(mergedPath))
 
-		// merge the dataset into a single file
-		mergedPath, err := task.Merge(schema, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Merged data written to %s", mergedPath)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// mergeRemote runs the denormalize pipeline through the TA2 and returns the
+// path of the merged schema.
+func mergeRemote(endpoint string, dataset string, schema string, input string, output string) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// merge the dataset into a single file
+	return task.Merge(schema, dataset, ingestConfig)
+}
@@ -0,0 +1,358 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/pkg/variables"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+// mergeReportFile is the name of the join report written next to the merged
+// schema when no report path is given.
+const mergeReportFile = "mergeReport.json"
+
+// joinReport describes how the resources of a dataset were joined. The
+// resources and joins are read from the source dataset before merging, and
+// the merged counts from the output once the merge is done.
+type joinReport struct {
+	Resources     []*resourceReport  `json:"resources"`
+	Joins         []*joinCheck       `json:"joins"`
+	Collisions    []*columnCollision `json:"collisions"`
+	MainRows      int                `json:"mainRows"`
+	MergedRows    int                `json:"mergedRows"`
+	RowsDropped   int                `json:"rowsDropped"`
+	RowsFannedOut int                `json:"rowsFannedOut"`
+}
+
+// resourceReport is the row count of a table resource.
+type resourceReport struct {
+	ResID string `json:"resID"`
+	Path  string `json:"path"`
+	Rows  int    `json:"rows"`
+}
+
+// joinCheck is the outcome of joining a table resource to another resource on
+// a foreign key. Rows with no matching key are dropped by the join, and keys
+// matching several rows fan out into one row per match.
+type joinCheck struct {
+	SourceResID string  `json:"sourceResID"`
+	Variable    string  `json:"variable"`
+	ResID       string  `json:"resID"`
+	Column      string  `json:"column"`
+	Matched     int     `json:"matched"`
+	Unmatched   int     `json:"unmatched"`
+	MatchRate   float64 `json:"matchRate"`
+	FannedOut   int     `json:"fannedOut"`
+}
+
+// columnCollision is a column name found in several joined resources, along
+// with the merged columns it ended up as.
+type columnCollision struct {
+	Column    string   `json:"column"`
+	Resources []string `json:"resources"`
+	Merged    []string `json:"merged"`
+}
+
+// sourceTable is the header and rows of a table resource.
+type sourceTable struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *sourceTable) column(name string) int {
+	for i, h := range t.header {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkJoins reads every table resource of the dataset and checks how the
+// foreign keys of each table resource match the resources they refer to.
+func checkJoins(schemaFile string) (*joinReport, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	report := &joinReport{
+		Resources:  []*resourceReport{},
+		Joins:      []*joinCheck{},
+		Collisions: []*columnCollision{},
+	}
+	tables := map[string]*sourceTable{}
+	for _, dr := range meta.DataResources {
+		if dr.ResType != model.ResTypeTable {
+			continue
+		}
+		dataPath := model.GetResourcePath(schemaFile, dr)
+		lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read resource %s", dr.ResID)
+		}
+		if len(lines) == 0 {
+			return nil, errors.Errorf("resource %s has no header", dr.ResID)
+		}
+		tables[dr.ResID] = &sourceTable{header: lines[0], rows: lines[1:]}
+		report.Resources = append(report.Resources, &resourceReport{
+			ResID: dr.ResID,
+			Path:  dr.ResPath,
+			Rows:  len(lines) - 1,
+		})
+	}
+	main := tables[mainDR.ResID]
+	if main == nil {
+		return nil, errors.Errorf("main resource %s is not a table", mainDR.ResID)
+	}
+	report.MainRows = len(main.rows)
+
+	// the resources each table resource joins to, and the key column of each
+	// referenced resource
+	references := map[string][]string{}
+	joinColumns := map[string]string{}
+	for _, dr := range meta.DataResources {
+		table := tables[dr.ResID]
+		if table == nil {
+			continue
+		}
+		for _, v := range dr.Variables {
+			resID, column := variables.ForeignKey(v)
+			referenced := tables[resID]
+			if column == "" || referenced == nil {
+				continue
+			}
+			check, err := checkJoin(dr.ResID, table, v, resID, referenced, column)
+			if err != nil {
+				return nil, err
+			}
+			report.Joins = append(report.Joins, check)
+			if !containsString(references[dr.ResID], resID) {
+				references[dr.ResID] = append(references[dr.ResID], resID)
+			}
+			if _, ok := joinColumns[resID]; !ok {
+				joinColumns[resID] = column
+			}
+		}
+	}
+
+	// the resources reachable from the main resource end up in the merge
+	joined := []string{mainDR.ResID}
+	for i := 0; i < len(joined); i++ {
+		for _, resID := range references[joined[i]] {
+			if !containsString(joined, resID) {
+				joined = append(joined, resID)
+			}
+		}
+	}
+
+	// a column name in more than one joined resource has to be renamed, other
+	// than the key the resource is joined on
+	owners := map[string][]string{}
+	for _, resID := range joined {
+		for _, name := range tables[resID].header {
+			if resID == mainDR.ResID || name != joinColumns[resID] {
+				owners[name] = append(owners[name], resID)
+			}
+		}
+	}
+	for name, resIDs := range owners {
+		if len(resIDs) > 1 {
+			report.Collisions = append(report.Collisions, &columnCollision{
+				Column:    name,
+				Resources: resIDs,
+				Merged:    []string{},
+			})
+		}
+	}
+	sort.Slice(report.Collisions, func(i, j int) bool {
+		return report.Collisions[i].Column < report.Collisions[j].Column
+	})
+
+	return report, nil
+}
+
+// checkJoin counts the rows of a table resource whose foreign key matches no
+// row, or several rows, of the referenced resource.
+func checkJoin(sourceResID string, source *sourceTable, v *model.Variable, resID string, referenced *sourceTable, column string) (*joinCheck, error) {
+	keyIndex := source.column(v.HeaderName)
+	referencedIndex := referenced.column(column)
+	if keyIndex < 0 || referencedIndex < 0 {
+		return nil, errors.Errorf("foreign key %s of resource %s refers to missing column %s of resource %s", v.Key, sourceResID, column, resID)
+	}
+
+	counts := map[string]int{}
+	for _, row := range referenced.rows {
+		if referencedIndex < len(row) {
+			counts[row[referencedIndex]]++
+		}
+	}
+	check := &joinCheck{
+		SourceResID: sourceResID,
+		Variable:    v.Key,
+		ResID:       resID,
+		Column:      column,
+	}
+	for _, row := range source.rows {
+		count := 0
+		if keyIndex < len(row) && row[keyIndex] != "" {
+			count = counts[row[keyIndex]]
+		}
+		if count == 0 {
+			check.Unmatched++
+			continue
+		}
+		check.Matched++
+		check.FannedOut += count - 1
+	}
+	check.MatchRate = 1
+	if len(source.rows) > 0 {
+		check.MatchRate = float64(check.Matched) / float64(len(source.rows))
+	}
+
+	return check, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// failedJoins lists the joins whose match rate is below the minimum.
+func (r *joinReport) failedJoins(minMatchRate float64) []*joinCheck {
+	failed := []*joinCheck{}
+	for _, j := range r.Joins {
+		if j.MatchRate < minMatchRate {
+			failed = append(failed, j)
+		}
+	}
+	return failed
+}
+
+// addMerged counts the rows of the merged output, using the d3mIndex to find
+// the source rows the merge dropped or repeated, and finds the merged columns
+// each colliding column ended up as.
+func (r *joinReport) addMerged(mergedSchema string) error {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(mergedSchema, false)
+	if err != nil {
+		return errors.Wrap(err, "unable to load merged schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(mergedSchema, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to read merged data")
+	}
+	if len(lines) == 0 {
+		return errors.New("merged data has no header")
+	}
+	header, rows := lines[0], lines[1:]
+	r.MergedRows = len(rows)
+
+	indexColumn := -1
+	for i, name := range header {
+		if name == model.D3MIndexFieldName {
+			indexColumn = i
+		}
+	}
+	if indexColumn < 0 {
+		// without an index only the overall row counts can be compared
+		if r.MergedRows > r.MainRows {
+			r.RowsFannedOut = r.MergedRows - r.MainRows
+		} else {
+			r.RowsDropped = r.MainRows - r.MergedRows
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, row := range rows {
+			if indexColumn >= len(row) {
+				continue
+			}
+			if seen[row[indexColumn]] {
+				r.RowsFannedOut++
+			}
+			seen[row[indexColumn]] = true
+		}
+		r.RowsDropped = r.MainRows - len(seen)
+		if r.RowsDropped < 0 {
+			// the merged indices are not those of the main resource
+			r.RowsDropped = 0
+		}
+	}
+
+	for _, c := range r.Collisions {
+		for _, name := range header {
+			if isMergedColumn(name, c.Column) {
+				c.Merged = append(c.Merged, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isMergedColumn checks whether a merged column is a copy of a source column.
+// The denormalize primitive keeps the names of the columns it joins and the
+// merge maps them back to the source variables by name, so a colliding column
+// is repeated under its own name. Reading such a file through pandas numbers
+// the repeats as '<name>.1', '<name>.2' and so on.
+func isMergedColumn(merged string, column string) bool {
+	if merged == column {
+		return true
+	}
+	suffix := strings.TrimPrefix(merged, column+".")
+	if suffix == merged || suffix == "" {
+		return false
+	}
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// writeJoinReport writes the join report as JSON.
+func writeJoinReport(filename string, report *joinReport) error {
+	bytes, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize join report")
+	}
+	err = os.MkdirAll(path.Dir(filename), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create join report directory")
+	}
+	err = ioutil.WriteFile(filename, bytes, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store join report")
+	}
+
+	return nil
+}
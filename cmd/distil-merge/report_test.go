@@ -0,0 +1,147 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const testJoinSchema = `{
+	"about": {
+		"datasetID": "join_test",
+		"datasetName": "join_test",
+		"datasetSchemaVersion": "4.0.0",
+		"license": "CC0"
+	},
+	"dataResources": [
+		{
+			"resID": "learningData",
+			"resPath": "tables/learningData.csv",
+			"resType": "table",
+			"resFormat": {"text/csv": ["csv"]},
+			"isCollection": false,
+			"columns": [
+				{"colIndex": 0, "colName": "d3mIndex", "colType": "integer", "role": ["index"]},
+				{"colIndex": 1, "colName": "name", "colType": "string", "role": ["attribute"]},
+				{"colIndex": 2, "colName": "storeID", "colType": "integer", "role": ["attribute"],
+					"refersTo": {"resID": "stores", "resObject": {"columnName": "storeID"}}}
+			]
+		},
+		{
+			"resID": "stores",
+			"resPath": "tables/stores.csv",
+			"resType": "table",
+			"resFormat": {"text/csv": ["csv"]},
+			"isCollection": false,
+			"columns": [
+				{"colIndex": 0, "colName": "storeID", "colType": "integer", "role": ["index"]},
+				{"colIndex": 1, "colName": "name", "colType": "string", "role": ["attribute"]},
+				{"colIndex": 2, "colName": "cityID", "colType": "integer", "role": ["attribute"],
+					"refersTo": {"resID": "cities", "resObject": {"columnName": "cityID"}}}
+			]
+		},
+		{
+			"resID": "cities",
+			"resPath": "tables/cities.csv",
+			"resType": "table",
+			"resFormat": {"text/csv": ["csv"]},
+			"isCollection": false,
+			"columns": [
+				{"colIndex": 0, "colName": "cityID", "colType": "integer", "role": ["index"]},
+				{"colIndex": 1, "colName": "name", "colType": "string", "role": ["attribute"]}
+			]
+		}
+	]
+}`
+
+func writeJoinDataset(t *testing.T, folder string) string {
+	files := map[string]string{
+		"datasetDoc.json":         testJoinSchema,
+		"tables/learningData.csv": "d3mIndex,name,storeID\n0,a,1\n1,b,2\n2,c,9\n",
+		"tables/stores.csv":       "storeID,name,cityID\n1,north,10\n2,south,11\n",
+		"tables/cities.csv":       "cityID,name\n10,paris\n",
+	}
+	for name, content := range files {
+		filename := path.Join(folder, name)
+		if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path.Join(folder, "datasetDoc.json")
+}
+
+func TestCheckJoins(t *testing.T) {
+	folder, err := ioutil.TempDir("", "merge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	report, err := checkJoins(writeJoinDataset(t, folder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []joinCheck{
+		{SourceResID: "learningData", Variable: "storeID", ResID: "stores", Column: "storeID", Matched: 2, Unmatched: 1},
+		{SourceResID: "stores", Variable: "cityID", ResID: "cities", Column: "cityID", Matched: 1, Unmatched: 1},
+	}
+	if len(report.Joins) != len(expected) {
+		t.Fatalf("found %d joins, expected %d", len(report.Joins), len(expected))
+	}
+	for i, e := range expected {
+		j := report.Joins[i]
+		if j.SourceResID != e.SourceResID || j.Variable != e.Variable || j.ResID != e.ResID || j.Column != e.Column ||
+			j.Matched != e.Matched || j.Unmatched != e.Unmatched {
+			t.Errorf("join %d is %+v, expected %+v", i, *j, e)
+		}
+	}
+	if failed := report.failedJoins(0.6); len(failed) != 1 || failed[0].SourceResID != "stores" {
+		t.Errorf("failed joins %v, expected the stores join", failed)
+	}
+
+	if len(report.Collisions) != 1 || report.Collisions[0].Column != "name" || len(report.Collisions[0].Resources) != 3 {
+		t.Errorf("unexpected collisions %+v", report.Collisions)
+	}
+}
+
+func TestIsMergedColumn(t *testing.T) {
+	tests := []struct {
+		merged   string
+		column   string
+		expected bool
+	}{
+		{"name", "name", true},
+		{"name.1", "name", true},
+		{"name.12", "name", true},
+		{"first_name", "name", false},
+		{"names", "name", false},
+		{"name.", "name", false},
+		{"name.x", "name", false},
+		{"id", "d3mIndex", false},
+	}
+	for _, test := range tests {
+		if actual := isMergedColumn(test.merged, test.column); actual != test.expected {
+			t.Errorf("isMergedColumn(%s, %s) = %v, expected %v", test.merged, test.column, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil/api/compute"
+	"github.com/uncharted-distil/distil/api/task"
+)
+
+const (
+	// numericBins is the number of equal frequency bins numeric columns are
+	// split into when computing the mutual information with another column.
+	numericBins = 10
+
+	// methodPCA runs the PCA ranking pipeline through the TA2, and all other
+	// methods rank the variables against the targets locally
+	methodPCA               = "pca"
+	methodAuto              = "auto"
+	methodCorrelation       = "correlation"
+	methodMutualInformation = "mutual-information"
+)
+
+// multiImportanceResult is the importance of the variables against several
+// targets. The features are those of the first target so the file can also be
+// read as a single ranking result.
+type multiImportanceResult struct {
+	Path     string               `json:"path"`
+	Features []float64            `json:"features"`
+	Targets  map[string][]float64 `json:"targets"`
+}
+
+// rankColumn is a column of the dataset along with whether it is numeric.
+type rankColumn struct {
+	name    string
+	values  []string
+	numeric bool
+	skip    bool
+}
+
+// loadProblemTargets reads the names of the targets of a problem.
+func loadProblemTargets(problemPath string) ([]string, error) {
+	problem, err := compute.LoadProblemSchemaFromFile(problemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []string{}
+	for _, data := range problem.Inputs.Data {
+		for _, target := range data.Targets {
+			targets = append(targets, target.ColName)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.Errorf("problem '%s' does not specify a target", problemPath)
+	}
+
+	return targets, nil
+}
+
+// rankLocal computes the importance of every column against each target and
+// writes it in the format produced by the ranking pipeline, adding the
+// importance against every target when there are several. The auto method
+// scores pairs of numeric columns by the absolute correlation and all other
+// pairs by the normalized mutual information, binning any numeric side. The
+// correlation method only scores numeric pairs, and the mutual information
+// method scores every pair. Only the first rows are read when the row limit is
+// positive.
+func rankLocal(datasetPath string, schemaPath string, classificationPath string, targets []string, method string,
+	rowLimit int, outputPath string) error {
+	columns, err := readRankColumns(datasetPath, schemaPath, classificationPath, rowLimit)
+	if err != nil {
+		return err
+	}
+
+	ranked := map[string][]float64{}
+	for _, target := range targets {
+		ranked[target], err = rankTarget(columns, target, method)
+		if err != nil {
+			return errors.Wrapf(err, "unable to rank %s", datasetPath)
+		}
+	}
+
+	var importance interface{}
+	if len(targets) == 1 {
+		importance = &task.ImportanceResult{
+			Path:     datasetPath,
+			Features: ranked[targets[0]],
+		}
+	} else {
+		importance = &multiImportanceResult{
+			Path:     datasetPath,
+			Features: ranked[targets[0]],
+			Targets:  ranked,
+		}
+	}
+	bytes, err := json.MarshalIndent(importance, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize ranking result")
+	}
+	err = os.MkdirAll(path.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create ranking output directory")
+	}
+	err = ioutil.WriteFile(outputPath, bytes, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store ranking result")
+	}
+
+	return nil
+}
+
+// rankTarget scores every column against the target using the method.
+func rankTarget(columns []*rankColumn, target string, method string) ([]float64, error) {
+	targetIndex := -1
+	for i, c := range columns {
+		if c.name == target {
+			targetIndex = i
+			break
+		}
+	}
+	if targetIndex < 0 {
+		return nil, errors.Errorf("target '%s' not found", target)
+	}
+	targetColumn := columns[targetIndex]
+
+	features := make([]float64, len(columns))
+	for i, c := range columns {
+		if i == targetIndex || c.skip {
+			continue
+		}
+		numeric := c.numeric && targetColumn.numeric
+		switch {
+		case method == methodMutualInformation || (method == methodAuto && !numeric):
+			features[i] = mutualInformation(discretize(c), discretize(targetColumn))
+		case numeric:
+			// rounding can push a perfect correlation past one
+			features[i] = math.Min(1, math.Abs(correlation(c.values, targetColumn.values)))
+		}
+	}
+
+	return features, nil
+}
+
+// readRankColumns reads the dataset by column, stopping after the row limit
+// when it is positive. Column types come from the schema when there is one,
+// classified when a classification is given with a merged schema, and
+// otherwise columns whose values all parse as numbers are numeric.
+func readRankColumns(datasetPath string, schemaPath string, classificationPath string, rowLimit int) ([]*rankColumn, error) {
+	file, err := os.Open(datasetPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open data file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	lines := [][]string{}
+	for rowLimit <= 0 || len(lines) <= rowLimit {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read data file")
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, errors.Errorf("data file %s is empty", datasetPath)
+	}
+
+	columns := make([]*rankColumn, len(lines[0]))
+	for i, name := range lines[0] {
+		values := make([]string, len(lines)-1)
+		for j, line := range lines[1:] {
+			if i < len(line) {
+				values[j] = line[i]
+			}
+		}
+		columns[i] = &rankColumn{
+			name:    name,
+			values:  values,
+			numeric: isNumericColumn(values),
+			skip:    name == model.D3MIndexFieldName,
+		}
+	}
+
+	if schemaPath != "" {
+		var meta *model.Metadata
+		if classificationPath != "" {
+			meta, err = metadata.LoadMetadataFromClassification(schemaPath, classificationPath, false, true)
+		} else {
+			meta, err = metadata.LoadMetadataFromOriginalSchema(schemaPath, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		variables := map[string]*model.Variable{}
+		for _, v := range meta.GetMainDataResource().Variables {
+			variables[v.HeaderName] = v
+		}
+		for _, c := range columns {
+			if v, ok := variables[c.name]; ok {
+				c.numeric = model.IsNumerical(v.Type)
+				c.skip = c.skip || model.IsIndexRole(v.SelectedRole)
+			}
+		}
+	}
+
+	return columns, nil
+}
+
+func isNumericColumn(values []string) bool {
+	count := 0
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return false
+		}
+		count++
+	}
+	return count > 0
+}
+
+// correlation is the Pearson correlation of the rows where both values are
+// numbers.
+func correlation(x []string, y []string) float64 {
+	var n, sumX, sumY, sumXX, sumYY, sumXY float64
+	for i := range x {
+		a, errX := strconv.ParseFloat(x[i], 64)
+		b, errY := strconv.ParseFloat(y[i], 64)
+		if errX != nil || errY != nil {
+			continue
+		}
+		n++
+		sumX += a
+		sumY += b
+		sumXX += a * a
+		sumYY += b * b
+		sumXY += a * b
+	}
+	if n < 2 {
+		return 0
+	}
+
+	denominator := math.Sqrt(n*sumXX-sumX*sumX) * math.Sqrt(n*sumYY-sumY*sumY)
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// discretize maps every value of the column to a category, splitting numeric
+// columns into equal frequency bins. Missing values map to the empty string.
+func discretize(c *rankColumn) []string {
+	if !c.numeric {
+		return c.values
+	}
+
+	parsed := []float64{}
+	for _, value := range c.values {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			parsed = append(parsed, f)
+		}
+	}
+	sort.Float64s(parsed)
+	if len(parsed) == 0 {
+		return make([]string, len(c.values))
+	}
+	edges := make([]float64, numericBins-1)
+	for i := range edges {
+		edges[i] = parsed[(i+1)*len(parsed)/numericBins]
+	}
+
+	bins := make([]string, len(c.values))
+	for i, value := range c.values {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		bins[i] = strconv.Itoa(sort.SearchFloat64s(edges, f))
+	}
+	return bins
+}
+
+// mutualInformation is the mutual information of the rows where both values
+// are present, normalized by the geometric mean of the two entropies. A first
+// column with a distinct value on every row identifies the rows rather than
+// predicting the second, so it scores zero.
+func mutualInformation(x []string, y []string) float64 {
+	joint := map[[2]string]float64{}
+	countsX := map[string]float64{}
+	countsY := map[string]float64{}
+	n := 0.0
+	for i := range x {
+		if x[i] == "" || y[i] == "" {
+			continue
+		}
+		joint[[2]string{x[i], y[i]}]++
+		countsX[x[i]]++
+		countsY[y[i]]++
+		n++
+	}
+	if n == 0 || (n > 1 && float64(len(countsX)) == n) {
+		return 0
+	}
+
+	mi := 0.0
+	for pair, count := range joint {
+		pXY := count / n
+		mi += pXY * math.Log(pXY/((countsX[pair[0]]/n)*(countsY[pair[1]]/n)))
+	}
+	normalizer := math.Sqrt(entropy(countsX, n) * entropy(countsY, n))
+	if normalizer == 0 {
+		return 0
+	}
+	return mi / normalizer
+}
+
+func entropy(counts map[string]float64, n float64) float64 {
+	h := 0.0
+	for _, count := range counts {
+		p := count / n
+		h -= p * math.Log(p)
+	}
+	return h
+}
@@ -0,0 +1,118 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMutualInformation(t *testing.T) {
+	tests := []struct {
+		name     string
+		x        []string
+		y        []string
+		expected float64
+	}{
+		{"identical", []string{"a", "a", "b", "b"}, []string{"a", "a", "b", "b"}, 1},
+		{"independent", []string{"a", "b", "a", "b"}, []string{"a", "a", "b", "b"}, 0},
+		{"unique identifier", []string{"1", "2", "3", "4"}, []string{"a", "a", "b", "b"}, 0},
+		{"missing values skipped", []string{"a", "", "b", "b", "a"}, []string{"a", "b", "b", "b", "a"}, 1},
+		{"no rows", []string{"", "a"}, []string{"a", ""}, 0},
+		{"constant", []string{"a", "a", "a"}, []string{"a", "b", "a"}, 0},
+	}
+	for _, test := range tests {
+		actual := mutualInformation(test.x, test.y)
+		if math.Abs(actual-test.expected) > 1e-9 {
+			t.Errorf("%s: mutualInformation = %f, expected %f", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestMutualInformationGeometricMean(t *testing.T) {
+	// x determines y, but y only partly determines x
+	x := []string{"a", "b", "c", "d", "a", "b", "c", "d"}
+	y := []string{"p", "p", "q", "q", "p", "p", "q", "q"}
+	expected := math.Sqrt(math.Log(2) / math.Log(4))
+	if actual := mutualInformation(x, y); math.Abs(actual-expected) > 1e-9 {
+		t.Errorf("mutualInformation = %f, expected %f", actual, expected)
+	}
+}
+
+const testMergedSchema = `{
+	"about": {"datasetID": "rank_test", "datasetName": "rank_test", "mergedSchema": "true"},
+	"dataResources": [{
+		"resID": "learningData",
+		"resPath": "tables/merged.csv",
+		"resType": "table",
+		"resFormat": {"text/csv": ["csv"]},
+		"columns": [
+			{"colIndex": 0, "colName": "d3mIndex", "colType": "integer", "role": ["index"]},
+			{"colIndex": 1, "colName": "count", "colType": "string", "role": ["attribute"]},
+			{"colIndex": 2, "colName": "city", "colType": "string", "role": ["attribute"]}
+		]
+	}]
+}`
+
+func TestReadRankColumnsClassification(t *testing.T) {
+	folder, err := ioutil.TempDir("", "rank-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	schemaPath := path.Join(folder, "mergedDatasetDoc.json")
+	datasetPath := path.Join(folder, "merged.csv")
+	classificationPath := path.Join(folder, "classification.json")
+	files := map[string]string{
+		schemaPath:         testMergedSchema,
+		datasetPath:        "d3mIndex,count,city\n0,1,Paris\n1,2,Lyon\n",
+		classificationPath: `{"labels": [["int"], ["int"], ["categorical"]], "label_probabilities": [[1], [0.9], [0.8]]}`,
+	}
+	for filename, content := range files {
+		err = ioutil.WriteFile(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	columns, err := readRankColumns(datasetPath, schemaPath, classificationPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		numeric bool
+		skip    bool
+	}{
+		{"d3mIndex", true, true},
+		{"count", true, false},
+		{"city", false, false},
+	}
+	if len(columns) != len(tests) {
+		t.Fatalf("read %d columns, expected %d", len(columns), len(tests))
+	}
+	for i, test := range tests {
+		actual := columns[i]
+		if actual.name != test.name || actual.numeric != test.numeric || actual.skip != test.skip {
+			t.Errorf("column %d is %s numeric %v skip %v, expected %s numeric %v skip %v", i, actual.name, actual.numeric,
+				actual.skip, test.name, test.numeric, test.skip)
+		}
+	}
+}
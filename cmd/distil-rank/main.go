@@ -19,16 +19,30 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strings"
 
 	"github.com/pkg/errors"
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
 )
 
+func splitAndTrim(arg string) []string {
+	var res []string
+	if arg == "" {
+		return res
+	}
+	split := strings.Split(arg, ",")
+	for _, str := range split {
+		res = append(res, strings.TrimSpace(str))
+	}
+	return res
+}
+
 func main() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -37,7 +51,7 @@ func main() {
 	app.Name = "distil-rank"
 	app.Version = "0.1.0"
 	app.Usage = "Rank D3M merged datasets"
-	app.UsageText = "distil-rank --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-rank [--endpoint=<url> | --local --target=<names>] [--method=<method>] [--row-limit=<rows>] --dataset=<filepath> --schema=<filepath> [--classification=<filepath>] --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "schema",
@@ -49,6 +63,11 @@ func main() {
 			Value: "",
 			Usage: "The dataset source path",
 		},
+		cli.StringFlag{
+			Name:  "classification",
+			Value: "",
+			Usage: "The classification file path, supplying the types of a merged schema in local mode",
+		},
 		cli.StringFlag{
 			Name:  "endpoint",
 			Value: "",
@@ -64,17 +83,71 @@ func main() {
 			Value: "",
 			Usage: "The ranking output file path",
 		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "Rank the variables against the targets without a TA2, writing the importance to the output path",
+		},
+		cli.StringFlag{
+			Name:  "target",
+			Value: "",
+			Usage: "The comma separated target variables to rank against in local mode",
+		},
+		cli.StringFlag{
+			Name:  "problem",
+			Value: "",
+			Usage: "The problemDoc to read the targets from when no target is specified",
+		},
+		cli.StringFlag{
+			Name:  "method",
+			Value: "",
+			Usage: "The ranking method (pca, auto, correlation or mutual-information), defaulting to auto in local mode and pca otherwise",
+		},
+		cli.IntFlag{
+			Name:  "row-limit",
+			Value: 0,
+			Usage: "The number of rows to rank on, with 0 using every row",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 
+		method := c.String("method")
+		if method == "" {
+			method = methodPCA
+			if c.Bool("local") {
+				method = methodAuto
+			}
+		}
+		local := method != methodPCA
+
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
-		if c.String("endpoint") == "" {
+		if c.String("endpoint") == "" && !local {
 			return cli.NewExitError("missing commandline flag `--endpoint`", 1)
 		}
 		if c.String("output") == "" {
-			return cli.NewExitError("missing commandline flag `--ranking-output`", 1)
+			return cli.NewExitError("missing commandline flag `--output`", 1)
+		}
+		if local && c.String("target") == "" && c.String("problem") == "" {
+			return cli.NewExitError("missing commandline flag `--target` or `--problem`", 1)
+		}
+		switch method {
+		case methodPCA:
+			if c.Bool("local") {
+				return cli.NewExitError("the pca method needs a TA2 and cannot run with `--local`", 1)
+			}
+			if c.String("target") != "" {
+				return cli.NewExitError("the pca method does not rank against a target", 1)
+			}
+		case methodAuto, methodCorrelation, methodMutualInformation:
+		default:
+			return cli.NewExitError(errors.Errorf("unsupported ranking method '%s'", method), 1)
+		}
+		if c.String("classification") != "" && c.String("schema") == "" {
+			return cli.NewExitError("`--classification` needs the merged schema passed with `--schema`", 1)
+		}
+		if c.Int("row-limit") < 0 {
+			return cli.NewExitError("`--row-limit` cannot be negative", 1)
 		}
 
 		endpoint := c.String("endpoint")
@@ -83,47 +156,110 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
+		rowLimit := c.Int("row-limit")
 
-		err = env.Initialize(&config)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		var rankingOutput string
+		if local {
+			targets := splitAndTrim(c.String("target"))
+			if len(targets) == 0 {
+				targets, err = loadProblemTargets(c.String("problem"))
+				if err != nil {
+					log.Errorf("%v", err)
+					return cli.NewExitError(errors.Cause(err), 2)
+				}
+			}
+			log.Infof("Ranking locally by %s against targets `%s`", method, strings.Join(targets, "`, `"))
+			err = rankLocal(dataset, schemaPath, c.String("classification"), targets, method, rowLimit, output)
+			rankingOutput = output
+		} else {
+			rankingOutput, err = rankRemote(endpoint, dataset, schemaPath, input, output, rowLimit)
 		}
-		ingestConfig := task.NewConfig(config)
-
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		defer client.Close()
-		task.SetClient(client)
+		log.Infof("Ranked data written to %s", working.SourcePath(rankingOutput))
 
-		// rank the dataset variable importance
-		rankingOutput, err := task.Rank(schemaPath, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("Ranked data written to %s", rankingOutput)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// rankRemote runs the PCA ranking pipeline through the TA2 and returns the
+// path of the importance file it wrote. A positive row limit runs the pipeline
+// on a copy of the first rows of the dataset.
+func rankRemote(endpoint string, dataset string, schemaPath string, input string, output string, rowLimit int) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+	ingestConfig.RankingRowLimit = rowLimit
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// rank the dataset variable importance
+	if rowLimit <= 0 {
+		return task.Rank(schemaPath, dataset, ingestConfig)
+	}
+
+	// the copy sits next to the dataset so the TA2 can read it
+	datasetFolder := path.Dir(schemaPath)
+	sampleFolder := path.Join(path.Dir(datasetFolder), path.Base(datasetFolder)+"-rows")
+	defer os.RemoveAll(sampleFolder)
+	sampleSchema, err := writeRowSample(schemaPath, rowLimit, sampleFolder)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("ranking the first %d rows", rowLimit)
+
+	sampleRanking, err := task.Rank(sampleSchema, dataset, ingestConfig)
+	if err != nil {
+		return "", err
+	}
+	rankingOutput := path.Join(datasetFolder, ingestConfig.RankingOutputPathRelative)
+	err = os.MkdirAll(path.Dir(rankingOutput), os.ModePerm)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create ranking output directory")
+	}
+	err = os.Rename(sampleRanking, rankingOutput)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to store ranking result")
+	}
+
+	return rankingOutput, nil
+}
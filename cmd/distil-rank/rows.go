@@ -0,0 +1,65 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+// writeRowSample writes a copy of the dataset holding its first rows to the
+// output folder, returning the path of the copied schema. Every variable is
+// kept so the ranking of the copy lines up with the original dataset.
+func writeRowSample(schemaFile string, rowLimit int, outputFolder string) (string, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading raw data")
+	}
+	if len(lines) > rowLimit+1 {
+		lines = lines[:rowLimit+1]
+	}
+
+	// the data path is kept relative so the copy can be read from the TA2
+	relativeData := path.Join(compute.D3MDataFolder, compute.D3MLearningData)
+	outputData := path.Join(outputFolder, relativeData)
+	datasetStorage := serialization.GetStorage(outputData)
+	err = datasetStorage.WriteData(outputData, lines)
+	if err != nil {
+		return "", errors.Wrap(err, "error writing row sample")
+	}
+	mainDR.ResPath = relativeData
+
+	outputSchema := path.Join(outputFolder, compute.D3MDataSchema)
+	err = datasetStorage.WriteMetadata(outputSchema, meta, true, false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to store row sample schema")
+	}
+
+	return outputSchema, nil
+}
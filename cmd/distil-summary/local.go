@@ -0,0 +1,323 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil/api/task"
+)
+
+const (
+	kindNumeric     = "numeric"
+	kindCategorical = "categorical"
+	kindDateTime    = "date/time"
+	kindText        = "text"
+
+	// categoricalMaxValues and categoricalMaxRatio bound the distinct values of
+	// a column inferred to be categorical
+	categoricalMaxValues = 100
+	categoricalMaxRatio  = 0.5
+	// dominantShare is the share of rows above which a single value is noted
+	dominantShare = 0.8
+	// skewThreshold is the absolute skewness above which a numeric column is
+	// noted as skewed
+	skewThreshold = 1.0
+	// maxListed caps the columns named in each sentence of the summary
+	maxListed = 5
+)
+
+var kindOrder = []string{kindNumeric, kindCategorical, kindDateTime, kindText}
+
+// summaryColumn is a column of the dataset with the kind used to describe it.
+type summaryColumn struct {
+	name    string
+	values  []string
+	kind    string
+	missing int
+}
+
+// summarizeLocal describes the dataset from its column types, row count,
+// missing values and notable distributions, and writes it in the format
+// produced by the summary pipeline.
+func summarizeLocal(datasetPath string, schemaPath string, outputPath string) error {
+	name, columns, err := readSummaryColumns(datasetPath, schemaPath)
+	if err != nil {
+		return err
+	}
+
+	sum := &task.SummaryResult{
+		Summary: describeDataset(name, columns),
+	}
+	bytes, err := json.MarshalIndent(sum, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize summary result")
+	}
+	err = os.MkdirAll(path.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to create summary output directory")
+	}
+	err = ioutil.WriteFile(outputPath, bytes, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "unable to store summary result")
+	}
+
+	return nil
+}
+
+// readSummaryColumns reads the dataset by column, taking the dataset name and
+// column types from the schema when there is one and inferring them from the
+// values otherwise.
+func readSummaryColumns(datasetPath string, schemaPath string) (string, []*summaryColumn, error) {
+	file, err := os.Open(datasetPath)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to open data file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read data file")
+	}
+	if len(lines) == 0 {
+		return "", nil, errors.Errorf("data file %s is empty", datasetPath)
+	}
+
+	columns := []*summaryColumn{}
+	for i, header := range lines[0] {
+		if header == model.D3MIndexFieldName {
+			continue
+		}
+		c := &summaryColumn{
+			name:   header,
+			values: make([]string, 0, len(lines)-1),
+		}
+		for _, line := range lines[1:] {
+			if i >= len(line) || strings.TrimSpace(line[i]) == "" {
+				c.missing++
+				continue
+			}
+			c.values = append(c.values, strings.TrimSpace(line[i]))
+		}
+		c.kind = inferKind(c.values)
+		columns = append(columns, c)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(datasetPath), filepath.Ext(datasetPath))
+	if schemaPath != "" {
+		meta, err := metadata.LoadMetadataFromOriginalSchema(schemaPath, false)
+		if err != nil {
+			return "", nil, err
+		}
+		name = meta.Name
+		variables := map[string]*model.Variable{}
+		for _, v := range meta.GetMainDataResource().Variables {
+			variables[v.HeaderName] = v
+		}
+		kept := []*summaryColumn{}
+		for _, c := range columns {
+			v, ok := variables[c.name]
+			if ok && model.IsIndexRole(v.SelectedRole) {
+				continue
+			}
+			if ok {
+				c.kind = typeKind(v.Type)
+			}
+			kept = append(kept, c)
+		}
+		columns = kept
+	}
+
+	return name, columns, nil
+}
+
+func typeKind(typ string) string {
+	switch {
+	case model.IsNumerical(typ):
+		return kindNumeric
+	case model.IsCategorical(typ):
+		return kindCategorical
+	case typ == model.DateTimeType || typ == model.TimestampType:
+		return kindDateTime
+	default:
+		return kindText
+	}
+}
+
+func inferKind(values []string) string {
+	numeric := len(values) > 0
+	distinct := map[string]bool{}
+	for _, value := range values {
+		distinct[value] = true
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			numeric = false
+		}
+	}
+	if numeric {
+		return kindNumeric
+	}
+	if len(values) > 0 && len(distinct) <= categoricalMaxValues && float64(len(distinct))/float64(len(values)) <= categoricalMaxRatio {
+		return kindCategorical
+	}
+	return kindText
+}
+
+// describeDataset builds the summary sentences.
+func describeDataset(name string, columns []*summaryColumn) string {
+	rows := 0
+	counts := map[string]int{}
+	for _, c := range columns {
+		counts[c.kind]++
+		if n := len(c.values) + c.missing; n > rows {
+			rows = n
+		}
+	}
+
+	kinds := []string{}
+	for _, kind := range kindOrder {
+		if counts[kind] > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	sentences := []string{fmt.Sprintf("%s has %d rows and %d columns (%s).", name, rows, len(columns), strings.Join(kinds, ", "))}
+
+	if rows == 0 {
+		return sentences[0]
+	}
+
+	missing := []*summaryColumn{}
+	for _, c := range columns {
+		if c.missing > 0 {
+			missing = append(missing, c)
+		}
+	}
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].missing > missing[j].missing
+	})
+	if len(missing) == 0 {
+		sentences = append(sentences, "No values are missing.")
+	} else {
+		listed := []string{}
+		for i, c := range missing {
+			if i == maxListed {
+				break
+			}
+			listed = append(listed, fmt.Sprintf("%s (%.1f%%)", c.name, 100*float64(c.missing)/float64(rows)))
+		}
+		sentences = append(sentences, fmt.Sprintf("Values are missing in %d of the columns, most in %s.", len(missing), strings.Join(listed, ", ")))
+	}
+
+	notes := []string{}
+	for _, c := range columns {
+		if note := describeColumn(c); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	if len(notes) > maxListed {
+		notes = notes[:maxListed]
+	}
+	for _, note := range notes {
+		sentences = append(sentences, note+".")
+	}
+
+	return strings.Join(sentences, " ")
+}
+
+// describeColumn notes anything unusual about the distribution of a column.
+func describeColumn(c *summaryColumn) string {
+	if len(c.values) == 0 {
+		return fmt.Sprintf("%s is empty", c.name)
+	}
+
+	frequencies := map[string]int{}
+	top := ""
+	for _, value := range c.values {
+		frequencies[value]++
+		if frequencies[value] > frequencies[top] || (frequencies[value] == frequencies[top] && value < top) {
+			top = value
+		}
+	}
+	if len(frequencies) == 1 {
+		return fmt.Sprintf("%s always has the value %s", c.name, top)
+	}
+
+	switch c.kind {
+	case kindNumeric:
+		skew := skewness(c.values)
+		if skew > skewThreshold {
+			return fmt.Sprintf("%s is right-skewed", c.name)
+		}
+		if skew < -skewThreshold {
+			return fmt.Sprintf("%s is left-skewed", c.name)
+		}
+	case kindCategorical:
+		share := float64(frequencies[top]) / float64(len(c.values))
+		if share >= dominantShare {
+			return fmt.Sprintf("%s is dominated by %s (%.0f%%)", c.name, top, 100*share)
+		}
+	case kindText:
+		if len(frequencies) == len(c.values) {
+			return fmt.Sprintf("%s is unique in every row", c.name)
+		}
+	}
+
+	return ""
+}
+
+func skewness(values []string) float64 {
+	parsed := make([]float64, 0, len(values))
+	mean := 0.0
+	for _, value := range values {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			parsed = append(parsed, f)
+			mean += f
+		}
+	}
+	if len(parsed) < 3 {
+		return 0
+	}
+	n := float64(len(parsed))
+	mean /= n
+
+	var m2, m3 float64
+	for _, f := range parsed {
+		d := f - mean
+		m2 += d * d
+		m3 += d * d * d
+	}
+	m2 /= n
+	m3 /= n
+	if m2 == 0 {
+		return 0
+	}
+	return m3 / math.Pow(m2, 1.5)
+}
@@ -0,0 +1,157 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const testSummarySchema = `{
+	"about": {
+		"datasetID": "summary_test",
+		"datasetName": "stores",
+		"datasetSchemaVersion": "4.0.0",
+		"license": "CC0"
+	},
+	"dataResources": [
+		{
+			"resID": "learningData",
+			"resPath": "tables/learningData.csv",
+			"resType": "table",
+			"resFormat": {"text/csv": ["csv"]},
+			"isCollection": false,
+			"columns": [
+				{"colIndex": 0, "colName": "d3mIndex", "colType": "integer", "role": ["index"]},
+				{"colIndex": 1, "colName": "code", "colType": "categorical", "role": ["attribute"]},
+				{"colIndex": 2, "colName": "sales", "colType": "real", "role": ["attribute"]},
+				{"colIndex": 3, "colName": "opened", "colType": "dateTime", "role": ["attribute"]}
+			]
+		}
+	]
+}`
+
+const testSummaryData = "d3mIndex,code,sales,opened\n" +
+	"0,1,10.5,2020-01-01\n" +
+	"1,2,,2020-02-01\n" +
+	"2,1,12,\n" +
+	"3,1,11,2020-04-01\n"
+
+func TestReadSummaryColumns(t *testing.T) {
+	folder, err := ioutil.TempDir("", "summary-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	dataPath := path.Join(folder, "tables", "learningData.csv")
+	schemaPath := path.Join(folder, "datasetDoc.json")
+	if err := os.MkdirAll(path.Dir(dataPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dataPath, []byte(testSummaryData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(schemaPath, []byte(testSummarySchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		schemaPath   string
+		expectedName string
+		kinds        []string
+		missing      []int
+	}{
+		{"inferred", "", "learningData", []string{kindNumeric, kindNumeric, kindText}, []int{0, 1, 1}},
+		{"schema types", schemaPath, "stores", []string{kindCategorical, kindNumeric, kindDateTime}, []int{0, 1, 1}},
+	}
+	for _, test := range tests {
+		name, columns, err := readSummaryColumns(dataPath, test.schemaPath)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if name != test.expectedName {
+			t.Errorf("%s: name %s, expected %s", test.name, name, test.expectedName)
+		}
+		names := []string{}
+		kinds := []string{}
+		missing := []int{}
+		for _, c := range columns {
+			names = append(names, c.name)
+			kinds = append(kinds, c.kind)
+			missing = append(missing, c.missing)
+		}
+		if expected := []string{"code", "sales", "opened"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: columns %v, expected %v", test.name, names, expected)
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) {
+			t.Errorf("%s: kinds %v, expected %v", test.name, kinds, test.kinds)
+		}
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("%s: missing %v, expected %v", test.name, missing, test.missing)
+		}
+	}
+
+	if err := ioutil.WriteFile(dataPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readSummaryColumns(dataPath, ""); err == nil {
+		t.Errorf("empty data file: expected an error")
+	}
+}
+
+func TestDescribeDataset(t *testing.T) {
+	tests := []struct {
+		name     string
+		columns  []*summaryColumn
+		expected string
+	}{
+		{
+			"no rows",
+			[]*summaryColumn{{name: "a", kind: kindNumeric}},
+			"test has 0 rows and 1 columns (1 numeric).",
+		},
+		{
+			"nothing missing",
+			[]*summaryColumn{
+				{name: "a", kind: kindNumeric, values: []string{"1", "2", "3"}},
+				{name: "b", kind: kindText, values: []string{"x", "y", "z"}},
+			},
+			"test has 3 rows and 2 columns (1 numeric, 1 text). No values are missing. b is unique in every row.",
+		},
+		{
+			"missing and notable columns",
+			[]*summaryColumn{
+				{name: "a", kind: kindNumeric, values: []string{"1", "1", "1", "1", "1", "1", "1", "1", "1", "100"}},
+				{name: "b", kind: kindCategorical, values: []string{"x", "x", "x", "x", "x", "x", "x", "x", "y"}, missing: 1},
+				{name: "c", kind: kindDateTime, values: []string{"2020-01-01"}, missing: 9},
+				{name: "d", kind: kindText, missing: 10},
+			},
+			"test has 10 rows and 4 columns (1 numeric, 1 categorical, 1 date/time, 1 text). " +
+				"Values are missing in 3 of the columns, most in d (100.0%), c (90.0%), b (10.0%). " +
+				"a is right-skewed. b is dominated by x (89%). c always has the value 2020-01-01. d is empty.",
+		},
+	}
+	for _, test := range tests {
+		if actual := describeDataset("test", test.columns); actual != test.expected {
+			t.Errorf("%s: describeDataset = %q, expected %q", test.name, actual, test.expected)
+		}
+	}
+}
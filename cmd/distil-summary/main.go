@@ -24,6 +24,7 @@ import (
 	log "github.com/unchartedsoftware/plog"
 	"github.com/urfave/cli"
 
+	"github.com/uncharted-distil/distil-ingest/pkg/parquet"
 	"github.com/uncharted-distil/distil/api/compute"
 	"github.com/uncharted-distil/distil/api/env"
 	"github.com/uncharted-distil/distil/api/task"
@@ -37,7 +38,7 @@ func main() {
 	app.Name = "distil-summary"
 	app.Version = "0.1.0"
 	app.Usage = "Summarize D3M datasets"
-	app.UsageText = "distil-summary --endpoint=<url> --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
+	app.UsageText = "distil-summary [--endpoint=<url> | --local] --dataset=<filepath> --schema=<filepath> --input=<filepath> --output=<filepath>"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "endpoint",
@@ -64,15 +65,22 @@ func main() {
 			Value: "",
 			Usage: "The summary output file path",
 		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "Describe the dataset without a TA2, writing the summary next to the schema, or to the output path without a schema",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		if c.String("endpoint") == "" {
+		if c.String("endpoint") == "" && !c.Bool("local") {
 			return cli.NewExitError("missing commandline flag `--rest-endpoint`", 1)
 		}
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
-		if c.String("output") == "" {
+		if c.Bool("local") && c.String("output") == "" && c.String("schema") == "" {
+			return cli.NewExitError("missing commandline flag `--schema`", 1)
+		}
+		if !c.Bool("local") && c.String("output") == "" {
 			return cli.NewExitError("missing commandline flag `--output`", 1)
 		}
 
@@ -82,47 +90,87 @@ func main() {
 		output := c.String("output")
 		input := c.String("input")
 
-		// initialize config
-		log.Infof("Using TA2 interface at `%s` ", endpoint)
-		config, err := env.LoadConfig()
+		working, err := parquet.NewWorkingCopy(schemaPath, dataset)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		config.SolutionComputeEndpoint = endpoint
-		config.D3MInputDir = input
-		config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+		defer working.Remove()
+		schemaPath, dataset = working.SchemaPath, working.DataPath
 
-		err = env.Initialize(&config)
-		if err != nil {
-			log.Errorf("%v", err)
-			return cli.NewExitError(errors.Cause(err), 2)
+		var summaryOutput string
+		if c.Bool("local") {
+			log.Infof("Using local summarizer")
+			summaryOutput, err = localSummaryPath(schemaPath, output)
+			if err == nil {
+				err = summarizeLocal(dataset, schemaPath, summaryOutput)
+			}
+		} else {
+			summaryOutput, err = summarizeRemote(endpoint, dataset, schemaPath, input, output)
 		}
-		ingestConfig := task.NewConfig(config)
-
-		// initialize the pipeline cache and queue
-		compute.InitializeCache(config.PipelineCacheFilename, true)
-		compute.InitializeQueue(&config)
-
-		// initialize client
-		client, err := task.NewDefaultClient(config, "distil-ingest", nil)
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		defer client.Close()
-		task.SetClient(client)
+		log.Infof("summarized data written to %s", working.SourcePath(summaryOutput))
 
-		// summarize the dataset
-		summaryOutput, err := task.Summarize(schemaPath, dataset, ingestConfig)
+		err = working.Finish()
 		if err != nil {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		log.Infof("summarized data written to %s", summaryOutput)
 
 		return nil
 	}
 	// run app
 	app.Run(os.Args)
 }
+
+// localSummaryPath is where the local summarizer writes its result, which is
+// where the TA2 summary ends up so ingest finds it. Without a schema the
+// output path is used.
+func localSummaryPath(schemaPath string, output string) (string, error) {
+	if schemaPath == "" {
+		return output, nil
+	}
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(schemaPath), task.NewConfig(config).SummaryMachineOutputPathRelative), nil
+}
+
+// summarizeRemote runs the summary pipeline through the TA2 and returns the
+// path of the summary it wrote.
+func summarizeRemote(endpoint string, dataset string, schemaPath string, input string, output string) (string, error) {
+	// initialize config
+	log.Infof("Using TA2 interface at `%s` ", endpoint)
+	config, err := env.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	config.SolutionComputeEndpoint = endpoint
+	config.D3MInputDir = input
+	config.D3MOutputDir = path.Dir(path.Dir(path.Dir(path.Dir(output))))
+
+	err = env.Initialize(&config)
+	if err != nil {
+		return "", err
+	}
+	ingestConfig := task.NewConfig(config)
+
+	// initialize the pipeline cache and queue
+	compute.InitializeCache(config.PipelineCacheFilename, true)
+	compute.InitializeQueue(&config)
+
+	// initialize client
+	client, err := task.NewDefaultClient(config, "distil-ingest", nil)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	task.SetClient(client)
+
+	// summarize the dataset
+	return task.Summarize(schemaPath, dataset, ingestConfig)
+}
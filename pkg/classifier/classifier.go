@@ -0,0 +1,24 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package classifier holds what the commands share about the way ingest
+// loads the classification.
+package classifier
+
+// NonBasicTypeWeight is the factor the probability of non basic types, such as
+// categorical or latitude, is multiplied by when ingest loads the
+// classification, as done by parseSuggestedTypes in distil-compute's
+// metadata package.
+const NonBasicTypeWeight = 1.5
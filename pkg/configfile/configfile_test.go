@@ -0,0 +1,79 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package configfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+type testRules struct {
+	Thresholds map[string]float64 `json:"thresholds" yaml:"thresholds"`
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		expected map[string]float64
+		fails    bool
+	}{
+		{"json", "rules.json", `{"thresholds": {"dateTime": 0.9}}`, map[string]float64{"dateTime": 0.9}, false},
+		{"yaml", "rules.yaml", "thresholds:\n  dateTime: 0.9\n", map[string]float64{"dateTime": 0.9}, false},
+		{"yml", "rules.yml", "thresholds:\n  dateTime: 0.9\n", map[string]float64{"dateTime": 0.9}, false},
+		{"unknown json field", "rules.json", `{"threshold": {"dateTime": 0.9}}`, nil, true},
+		{"unknown yaml field", "rules.yaml", "threshold:\n  dateTime: 0.9\n", nil, true},
+		{"invalid json", "rules.json", `{"thresholds":`, nil, true},
+	}
+
+	dir, err := ioutil.TempDir("", "configfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		filename := path.Join(dir, test.filename)
+		err := ioutil.WriteFile(filename, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rules := &testRules{}
+		err = Read(filename, rules)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rules.Thresholds, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, rules.Thresholds, test.expected)
+		}
+	}
+
+	if err := Read(path.Join(dir, "missing.json"), &testRules{}); err == nil {
+		t.Errorf("missing file: expected an error")
+	}
+}
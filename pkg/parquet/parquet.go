@@ -0,0 +1,518 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package parquet lets the ingest commands run on datasets stored as parquet
+// files. The pipelines and local readers only read CSV data, so a working copy
+// of the dataset with its parquet resources converted to CSV is built for the
+// command to run on, leaving the source dataset as it was.
+package parquet
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+const (
+	// Extension is the extension of parquet files.
+	Extension = ".parquet"
+
+	// workingFolderPrefix follows the name of the dataset folder in the name
+	// of its working copies
+	workingFolderPrefix = "-csv-"
+)
+
+// WorkingCopy is a copy of a dataset with its parquet resources converted to
+// CSV files. Other files are linked to the source files so anything written to
+// them lands in the source dataset. Finish moves the files a command writes in
+// the working copy back to the source dataset.
+type WorkingCopy struct {
+	// SchemaPath is the schema of the working copy.
+	SchemaPath string
+	// DataPath is the data file of the working copy.
+	DataPath string
+
+	sourceSchema string
+	sourceFolder string
+	folder       string
+	written      map[string]os.FileInfo
+	formats      map[string]resourceFormat
+}
+
+// resourceFormat is the path and format of a converted resource in the source
+// schema.
+type resourceFormat struct {
+	resPath   string
+	resFormat interface{}
+}
+
+// IsParquet returns true when the file is a parquet file.
+func IsParquet(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), Extension)
+}
+
+// NewWorkingCopy builds a working copy of the dataset when the schema lists
+// parquet resources or the data file is a parquet file. The working copy is
+// a new `<dataset folder>-csv-<random>` folder next to the dataset folder so
+// it stays visible to the TA2, and commands running on the same dataset each
+// get their own. When there is nothing to convert, the working copy is the
+// source dataset itself.
+func NewWorkingCopy(schemaFile string, dataPath string) (*WorkingCopy, error) {
+	w := &WorkingCopy{
+		SchemaPath:   schemaFile,
+		DataPath:     dataPath,
+		sourceSchema: schemaFile,
+		written:      map[string]os.FileInfo{},
+		formats:      map[string]resourceFormat{},
+	}
+
+	var schema map[string]interface{}
+	parquetResources := []map[string]interface{}{}
+	if schemaFile != "" {
+		var err error
+		schema, err = readSchema(schemaFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range schemaResources(schema) {
+			resPath, _ := resource["resPath"].(string)
+			if IsParquet(resPath) {
+				parquetResources = append(parquetResources, resource)
+			}
+		}
+	}
+	if len(parquetResources) == 0 && !IsParquet(dataPath) {
+		return w, nil
+	}
+
+	if schemaFile == "" {
+		folder, err := ioutil.TempDir("", "distil-parquet")
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create working folder")
+		}
+		w.folder = folder
+		w.DataPath = path.Join(folder, csvName(path.Base(dataPath)))
+		err = w.convert(dataPath, w.DataPath)
+		if err != nil {
+			w.Remove()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	sourceFolder, err := filepath.Abs(path.Dir(schemaFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve dataset folder")
+	}
+	sourceInfo, err := os.Stat(sourceFolder)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read dataset folder")
+	}
+	folder, err := ioutil.TempDir(path.Dir(sourceFolder), path.Base(sourceFolder)+workingFolderPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create working folder")
+	}
+	w.sourceFolder = sourceFolder
+	w.folder = folder
+	w.SchemaPath = path.Join(w.folder, path.Base(schemaFile))
+
+	// the temporary folder is only open to its owner
+	err = os.Chmod(w.folder, sourceInfo.Mode().Perm()|0700)
+	if err != nil {
+		w.Remove()
+		return nil, errors.Wrapf(err, "unable to open working folder %s", w.folder)
+	}
+	err = w.build(schemaFile, schema, parquetResources, dataPath)
+	if err != nil {
+		w.Remove()
+		return nil, err
+	}
+	log.Infof("working on a CSV copy of %s in %s", sourceFolder, w.folder)
+
+	return w, nil
+}
+
+// build converts the parquet resources, links the other files of the source
+// dataset and writes the schema of the working copy.
+func (w *WorkingCopy) build(schemaFile string, schema map[string]interface{},
+	parquetResources []map[string]interface{}, dataPath string) error {
+	// parquet files converted to CSV files, both relative to the folders
+	converted := map[string]string{}
+	for _, resource := range parquetResources {
+		resPath := resource["resPath"].(string)
+		rel, ok := resourcePath(w.sourceFolder, resPath)
+		if !ok {
+			rel = path.Base(resPath)
+		}
+		csvRel := csvName(rel)
+		if _, ok := converted[rel]; !ok {
+			source := resPath
+			if !filepath.IsAbs(source) {
+				source = path.Join(w.sourceFolder, resPath)
+			}
+			err := w.convert(source, path.Join(w.folder, csvRel))
+			if err != nil {
+				return err
+			}
+			converted[rel] = csvRel
+		}
+		w.formats[csvRel] = resourceFormat{resPath: resPath, resFormat: resource["resFormat"]}
+		resource["resPath"] = csvRel
+		resource["resFormat"] = map[string]interface{}{"text/csv": []string{"csv"}}
+	}
+
+	// the data file is read from the working copy when it is in the dataset
+	if dataPath != "" {
+		rel, inside := relativePath(w.sourceFolder, dataPath)
+		switch {
+		case inside && IsParquet(rel):
+			if _, ok := converted[rel]; !ok {
+				err := w.convert(dataPath, path.Join(w.folder, csvName(rel)))
+				if err != nil {
+					return err
+				}
+				converted[rel] = csvName(rel)
+			}
+			w.DataPath = path.Join(w.folder, converted[rel])
+		case inside:
+			w.DataPath = path.Join(w.folder, rel)
+		case IsParquet(dataPath):
+			w.DataPath = path.Join(w.folder, csvName(path.Base(dataPath)))
+			err := w.convert(dataPath, w.DataPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := filepath.Walk(w.sourceFolder, func(source string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(w.sourceFolder, source)
+		target := path.Join(w.folder, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if _, ok := converted[rel]; ok || rel == path.Base(schemaFile) {
+			return nil
+		}
+		if _, err := os.Lstat(target); err == nil {
+			// a converted file takes the place of the source file
+			return nil
+		}
+		return w.link(source, target)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to copy %s", w.sourceFolder)
+	}
+
+	return w.writeSchema(w.SchemaPath, schema)
+}
+
+// Finish moves the files written in the working copy to the source dataset
+// and removes the working copy. A schema rewritten in the working copy is
+// pointed back at the parquet resources it did not replace.
+func (w *WorkingCopy) Finish() error {
+	defer w.Remove()
+	if w.sourceFolder == "" {
+		return nil
+	}
+
+	err := filepath.Walk(w.folder, func(working string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.folder, working)
+		source := path.Join(w.sourceFolder, rel)
+		if sourceInfo, err := os.Stat(source); err == nil && os.SameFile(info, sourceInfo) {
+			// still linked to the source file
+			return nil
+		}
+		if written, ok := w.written[rel]; ok && written.ModTime().Equal(info.ModTime()) && written.Size() == info.Size() {
+			return nil
+		}
+
+		err = os.MkdirAll(path.Dir(source), 0755)
+		if err != nil {
+			return err
+		}
+		if rel == path.Base(w.sourceSchema) {
+			return w.restoreSchema(working, source)
+		}
+		log.Infof("moving %s to %s", working, source)
+		return move(working, source)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to move the output of %s to %s", w.folder, w.sourceFolder)
+	}
+
+	return nil
+}
+
+// Remove removes the working copy without moving its files.
+func (w *WorkingCopy) Remove() {
+	if w.folder == "" {
+		return
+	}
+	err := os.RemoveAll(w.folder)
+	if err != nil {
+		log.Warnf("unable to remove working folder %s: %v", w.folder, err)
+	}
+}
+
+// SourcePath returns the path a file of the working copy has, or will have
+// once moved, in the source dataset. Other paths are returned unchanged.
+func (w *WorkingCopy) SourcePath(filename string) string {
+	if w.folder == "" || w.sourceFolder == "" {
+		return filename
+	}
+	rel, ok := relativePath(w.folder, filename)
+	if !ok {
+		return filename
+	}
+	return path.Join(w.sourceFolder, rel)
+}
+
+// restoreSchema writes a schema rewritten in the working copy to the source
+// dataset, pointing resources that still read an unchanged converted CSV file
+// at the parquet file it was converted from.
+func (w *WorkingCopy) restoreSchema(working string, source string) error {
+	schema, err := readSchema(working)
+	if err != nil {
+		return err
+	}
+	for _, resource := range schemaResources(schema) {
+		resPath, _ := resource["resPath"].(string)
+		rel, ok := resourcePath(w.folder, resPath)
+		if !ok {
+			continue
+		}
+		format, ok := w.formats[rel]
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path.Join(w.folder, rel))
+		if err != nil {
+			continue
+		}
+		if written, ok := w.written[rel]; ok && written.ModTime().Equal(info.ModTime()) && written.Size() == info.Size() {
+			resource["resPath"] = format.resPath
+			resource["resFormat"] = format.resFormat
+		}
+	}
+	log.Infof("writing %s to %s", working, source)
+
+	return w.writeSchema(source, schema)
+}
+
+// resourcePath returns the path of a resource relative to a dataset folder
+// when the resource is in the folder.
+func resourcePath(folder string, resPath string) (string, bool) {
+	if !filepath.IsAbs(resPath) {
+		return path.Clean(resPath), true
+	}
+	return relativePath(folder, resPath)
+}
+
+// relativePath returns the path of a file relative to a folder when the file
+// is in the folder.
+func relativePath(folder string, filename string) (string, bool) {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(folder, absolute)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// convert writes a parquet file as a CSV file in the working copy.
+func (w *WorkingCopy) convert(parquetPath string, csvPath string) error {
+	data, err := serialization.GetParquetStorage().ReadData(parquetPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", parquetPath)
+	}
+	err = os.MkdirAll(path.Dir(csvPath), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", path.Dir(csvPath))
+	}
+	err = serialization.GetCSVStorage().WriteData(csvPath, data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write %s", csvPath)
+	}
+	log.Infof("converted %s to %s", parquetPath, csvPath)
+
+	return w.record(csvPath)
+}
+
+// link hard links a file of the source dataset into the working copy, falling
+// back to a copy across file systems.
+func (w *WorkingCopy) link(source string, target string) error {
+	if os.Link(source, target) == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	return w.record(target)
+}
+
+// writeSchema writes a schema, recording it when it is in the working copy.
+func (w *WorkingCopy) writeSchema(schemaFile string, schema map[string]interface{}) error {
+	output, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode schema")
+	}
+	err = ioutil.WriteFile(schemaFile, output, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write %s", schemaFile)
+	}
+	if path.Dir(schemaFile) == w.folder {
+		return w.record(schemaFile)
+	}
+	return nil
+}
+
+// record keeps the state of a file written in the working copy so Finish can
+// tell whether the command changed it.
+func (w *WorkingCopy) record(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to stat %s", filename)
+	}
+	if w.sourceFolder == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(w.folder, filename)
+	if err != nil {
+		return errors.Wrapf(err, "unable to record %s", filename)
+	}
+	w.written[rel] = info
+
+	return nil
+}
+
+// WriteOutput rewrites the main resource of the dataset as a parquet file,
+// removing the CSV file and pointing the schema at the parquet file.
+func WriteOutput(schemaFile string) (string, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load output schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	if IsParquet(dataPath) {
+		return dataPath, nil
+	}
+	data, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read %s", dataPath)
+	}
+	parquetPath := strings.TrimSuffix(dataPath, path.Ext(dataPath)) + Extension
+	err = serialization.GetParquetStorage().WriteData(parquetPath, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to write %s", parquetPath)
+	}
+
+	// the parquet storage points the main resource at the parquet file
+	err = serialization.GetParquetStorage().WriteMetadata(schemaFile, meta, true, true)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to store parquet schema")
+	}
+	err = os.Remove(dataPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to remove %s", dataPath)
+	}
+
+	return parquetPath, nil
+}
+
+// readSchema reads a schema as generic JSON so it can be written back with
+// only the resources changed.
+func readSchema(schemaFile string) (map[string]interface{}, error) {
+	input, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", schemaFile)
+	}
+	schema := map[string]interface{}{}
+	err = json.Unmarshal(input, &schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", schemaFile)
+	}
+	return schema, nil
+}
+
+// schemaResources returns the data resources of a schema.
+func schemaResources(schema map[string]interface{}) []map[string]interface{} {
+	resources := []map[string]interface{}{}
+	list, _ := schema["dataResources"].([]interface{})
+	for _, r := range list {
+		if resource, ok := r.(map[string]interface{}); ok {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// csvName replaces the extension of a file with the CSV extension.
+func csvName(filename string) string {
+	return strings.TrimSuffix(filename, path.Ext(filename)) + ".csv"
+}
+
+// move renames a file, copying it when the rename crosses file systems.
+func move(source string, target string) error {
+	if os.Rename(source, target) == nil {
+		return nil
+	}
+	input, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, input, 0644)
+}
@@ -0,0 +1,245 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package parquet
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+const testSchema = `{
+	"about": {
+		"datasetID": "test",
+		"license": "CC0"
+	},
+	"dataResources": [
+		{
+			"resID": "learningData",
+			"resPath": "tables/learningData.parquet",
+			"resType": "table",
+			"resFormat": {
+				"application/parquet": [
+					"parquet"
+				]
+			}
+		}
+	]
+}`
+
+// writeTestDataset writes a dataset with a parquet main resource in a
+// temporary folder and returns the path of its schema.
+func writeTestDataset(t *testing.T) string {
+	folder, err := ioutil.TempDir("", "parquet-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataset := path.Join(folder, "test_dataset")
+	err = os.MkdirAll(path.Join(dataset, "tables"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = serialization.GetParquetStorage().WriteData(path.Join(dataset, "tables", "learningData.parquet"),
+		[][]string{{"d3mIndex", "name"}, {"0", "alpha"}, {"1", "beta"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dataset, "notes.txt"), []byte("notes"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaPath := path.Join(dataset, "datasetDoc.json")
+	err = ioutil.WriteFile(schemaPath, []byte(testSchema), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schemaPath
+}
+
+func readFile(t *testing.T, filename string) string {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestWorkingCopyLeavesSourceUntouched(t *testing.T) {
+	schemaPath := writeTestDataset(t)
+	dataset := path.Dir(schemaPath)
+	defer os.RemoveAll(path.Dir(dataset))
+
+	working, err := NewWorkingCopy(schemaPath, path.Join(dataset, "tables", "learningData.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if working.SchemaPath == schemaPath || !strings.HasSuffix(working.DataPath, "learningData.csv") {
+		t.Fatalf("unexpected working copy %s, %s", working.SchemaPath, working.DataPath)
+	}
+	if !strings.Contains(readFile(t, working.SchemaPath), "tables/learningData.csv") {
+		t.Errorf("working schema does not read the CSV file")
+	}
+	if !strings.Contains(readFile(t, working.DataPath), "alpha") {
+		t.Errorf("working data was not converted")
+	}
+	if readFile(t, path.Join(path.Dir(working.SchemaPath), "notes.txt")) != "notes" {
+		t.Errorf("working copy is missing the other files")
+	}
+
+	err = working.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, schemaPath) != testSchema {
+		t.Errorf("source schema was changed")
+	}
+	if _, err := os.Stat(path.Join(dataset, "tables", "learningData.csv")); err == nil {
+		t.Errorf("converted CSV file was left in the source dataset")
+	}
+	if _, err := os.Stat(path.Dir(working.SchemaPath)); err == nil {
+		t.Errorf("working folder was not removed")
+	}
+}
+
+func TestWorkingCopyKeepsOtherFolders(t *testing.T) {
+	schemaPath := writeTestDataset(t)
+	dataset := path.Dir(schemaPath)
+	defer os.RemoveAll(path.Dir(dataset))
+
+	// a folder named like a working copy that belongs to someone else
+	sibling := path.Join(dataset+"-csv", "keep.txt")
+	err := os.MkdirAll(path.Dir(sibling), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(sibling, []byte("keep"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := NewWorkingCopy(schemaPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewWorkingCopy(schemaPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Dir(first.SchemaPath) == path.Dir(second.SchemaPath) {
+		t.Fatalf("working copies share the folder %s", path.Dir(first.SchemaPath))
+	}
+
+	err = first.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second.SchemaPath); err != nil {
+		t.Errorf("finishing a working copy removed another one: %v", err)
+	}
+	err = second.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, sibling) != "keep" {
+		t.Errorf("unrelated folder next to the dataset was changed")
+	}
+}
+
+func TestWorkingCopyMovesOutputs(t *testing.T) {
+	tests := []struct {
+		name        string
+		dataFile    string
+		resPath     string
+		csvInSource bool
+	}{
+		{"schema only", "", "tables/learningData.parquet", false},
+		{"rewritten data", "tables/learningData.csv", "tables/learningData.csv", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schemaPath := writeTestDataset(t)
+			dataset := path.Dir(schemaPath)
+			defer os.RemoveAll(path.Dir(dataset))
+			working, err := NewWorkingCopy(schemaPath, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			workingFolder := path.Dir(working.SchemaPath)
+
+			// a command writes a result and rewrites the schema
+			err = ioutil.WriteFile(path.Join(workingFolder, "classification.json"), []byte("{}"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.dataFile != "" {
+				err = ioutil.WriteFile(path.Join(workingFolder, test.dataFile), []byte("d3mIndex,name\n0,gamma\n"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			schema := strings.Replace(readFile(t, working.SchemaPath), `"test"`, `"test_output"`, 1)
+			err = ioutil.WriteFile(working.SchemaPath, []byte(schema), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if working.SourcePath(path.Join(workingFolder, "classification.json")) != path.Join(dataset, "classification.json") {
+				t.Errorf("unexpected source path %s", working.SourcePath(path.Join(workingFolder, "classification.json")))
+			}
+
+			err = working.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if readFile(t, path.Join(dataset, "classification.json")) != "{}" {
+				t.Errorf("result was not moved to the source dataset")
+			}
+			output := readFile(t, schemaPath)
+			if !strings.Contains(output, "test_output") || !strings.Contains(output, `"`+test.resPath+`"`) {
+				t.Errorf("unexpected output schema %s", output)
+			}
+			if !strings.Contains(output, `"license": "CC0"`) {
+				t.Errorf("output schema lost the license")
+			}
+			_, err = os.Stat(path.Join(dataset, "tables", "learningData.csv"))
+			if (err == nil) != test.csvInSource {
+				t.Errorf("expected CSV file in source dataset %v", test.csvInSource)
+			}
+		})
+	}
+}
+
+func TestSourcePath(t *testing.T) {
+	working := &WorkingCopy{folder: "/data/test_dataset-csv", sourceFolder: "/data/test_dataset"}
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"/data/test_dataset-csv/datasetDoc.json", "/data/test_dataset/datasetDoc.json"},
+		{"/data/test_dataset-csv/tables/learningData.csv", "/data/test_dataset/tables/learningData.csv"},
+		{"/data/output/ranking.csv", "/data/output/ranking.csv"},
+		{"/data/test_dataset/datasetDoc.json", "/data/test_dataset/datasetDoc.json"},
+	}
+	for _, test := range tests {
+		if actual := working.SourcePath(test.filename); actual != test.expected {
+			t.Errorf("SourcePath(%s) = %s, expected %s", test.filename, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package variables selects the variables the commands work on and reads
+// what the commands share about them.
+package variables
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/metadata"
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil/api/serialization"
+)
+
+// Filter limits the work to the variables named by `--variables`,
+// less the ones named by `--exclude-variables`. Variables are named by key or
+// header name.
+type Filter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// NewFilter creates a filter selecting the included variables, or every
+// variable when none are included, less the excluded variables.
+func NewFilter(include []string, exclude []string) *Filter {
+	f := &Filter{
+		include: map[string]bool{},
+		exclude: map[string]bool{},
+	}
+	for _, name := range include {
+		f.include[name] = true
+	}
+	for _, name := range exclude {
+		f.exclude[name] = true
+	}
+	return f
+}
+
+// Empty is true when the filter selects every variable.
+func (f *Filter) Empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Selected checks whether the variable known by any of the names is selected.
+func (f *Filter) Selected(names ...string) bool {
+	included := len(f.include) == 0
+	for _, name := range names {
+		if f.exclude[name] {
+			return false
+		}
+		included = included || f.include[name]
+	}
+	return included
+}
+
+// Validate checks that every named variable is one of the known names.
+func (f *Filter) Validate(names []string) error {
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, filter := range []map[string]bool{f.include, f.exclude} {
+		for name := range filter {
+			if !known[name] {
+				return errors.Errorf("variable '%s' not found", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Subset is a copy of a dataset holding some of its variables.
+type Subset struct {
+	SchemaPath string
+	DataPath   string
+	// Indices holds the index in the original dataset of each copied variable
+	Indices []int
+	// ColumnCount is the number of variables of the original dataset
+	ColumnCount int
+}
+
+// WriteSubset writes a copy of the dataset holding only the selected
+// variables and the index to the output folder.
+func WriteSubset(schemaFile string, filter *Filter, outputFolder string) (*Subset, error) {
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load original schema file")
+	}
+	mainDR := meta.GetMainDataResource()
+
+	names := []string{}
+	for _, v := range mainDR.Variables {
+		names = append(names, v.Key, v.HeaderName)
+	}
+	err = filter.Validate(names)
+	if err != nil {
+		return nil, err
+	}
+
+	dataPath := model.GetResourcePath(schemaFile, mainDR)
+	lines, err := serialization.GetStorage(dataPath).ReadData(dataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading raw data")
+	}
+
+	columnCount := len(mainDR.Variables)
+	kept := []*model.Variable{}
+	originalIndices := []int{}
+	for _, v := range mainDR.Variables {
+		if model.IsIndexRole(v.SelectedRole) || v.Key == model.D3MIndexFieldName || filter.Selected(v.Key, v.HeaderName) {
+			originalIndices = append(originalIndices, v.Index)
+			v.Index = len(kept)
+			kept = append(kept, v)
+		}
+	}
+	mainDR.Variables = kept
+
+	output := make([][]string, len(lines))
+	for i, line := range lines {
+		output[i] = make([]string, len(originalIndices))
+		for j, index := range originalIndices {
+			if index < len(line) {
+				output[i][j] = line[index]
+			}
+		}
+	}
+
+	// the data path is kept relative so the copy can be read from the TA2
+	relativeData := path.Join(compute.D3MDataFolder, compute.D3MLearningData)
+	outputData := path.Join(outputFolder, relativeData)
+	datasetStorage := serialization.GetStorage(outputData)
+	err = datasetStorage.WriteData(outputData, output)
+	if err != nil {
+		return nil, errors.Wrap(err, "error writing dataset subset")
+	}
+	mainDR.ResPath = relativeData
+
+	outputSchema := path.Join(outputFolder, compute.D3MDataSchema)
+	err = datasetStorage.WriteMetadata(outputSchema, meta, true, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to store dataset subset schema")
+	}
+
+	return &Subset{
+		SchemaPath:  outputSchema,
+		DataPath:    outputData,
+		Indices:     originalIndices,
+		ColumnCount: columnCount,
+	}, nil
+}
+
+// ForeignKey returns the resource and column a variable refers to, which are
+// empty when the variable is not a foreign key to a column.
+func ForeignKey(v *model.Variable) (string, string) {
+	if v.RefersTo == nil {
+		return "", ""
+	}
+	resID, _ := v.RefersTo["resID"].(string)
+	resObject, ok := v.RefersTo["resObject"].(map[string]interface{})
+	if !ok {
+		return "", ""
+	}
+	column, _ := resObject["columnName"].(string)
+	return resID, column
+}
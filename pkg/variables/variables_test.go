@@ -0,0 +1,158 @@
+//
+//   Copyright © 2021 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package variables
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+func TestFilterSelected(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		names    []string
+		expected bool
+	}{
+		{"empty filter", nil, nil, []string{"a"}, true},
+		{"included key", []string{"a"}, nil, []string{"a", "A"}, true},
+		{"included header name", []string{"A"}, nil, []string{"a", "A"}, true},
+		{"not included", []string{"b"}, nil, []string{"a", "A"}, false},
+		{"excluded", nil, []string{"a"}, []string{"a", "A"}, false},
+		{"excluded wins", []string{"a"}, []string{"A"}, []string{"a", "A"}, false},
+	}
+	for _, test := range tests {
+		filter := NewFilter(test.include, test.exclude)
+		if actual := filter.Selected(test.names...); actual != test.expected {
+			t.Errorf("%s: Selected(%v) = %v, expected %v", test.name, test.names, actual, test.expected)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		valid   bool
+	}{
+		{nil, nil, true},
+		{[]string{"a"}, []string{"b"}, true},
+		{[]string{"c"}, nil, false},
+		{nil, []string{"c"}, false},
+	}
+	for _, test := range tests {
+		err := NewFilter(test.include, test.exclude).Validate([]string{"a", "b"})
+		if (err == nil) != test.valid {
+			t.Errorf("Validate with include %v and exclude %v returned %v", test.include, test.exclude, err)
+		}
+	}
+}
+
+func TestForeignKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		refersTo map[string]interface{}
+		resID    string
+		column   string
+	}{
+		{"not a foreign key", nil, "", ""},
+		{"column", map[string]interface{}{"resID": "stores", "resObject": map[string]interface{}{"columnName": "storeID"}}, "stores", "storeID"},
+		{"whole resource", map[string]interface{}{"resID": "images", "resObject": "item"}, "", ""},
+		{"missing column", map[string]interface{}{"resID": "stores", "resObject": map[string]interface{}{}}, "stores", ""},
+	}
+	for _, test := range tests {
+		resID, column := ForeignKey(&model.Variable{RefersTo: test.refersTo})
+		if resID != test.resID || column != test.column {
+			t.Errorf("%s: ForeignKey = %s, %s, expected %s, %s", test.name, resID, column, test.resID, test.column)
+		}
+	}
+}
+
+const testSubsetSchema = `{
+	"about": {
+		"datasetID": "subset_test",
+		"datasetName": "subset_test",
+		"datasetSchemaVersion": "4.0.0",
+		"license": "CC0"
+	},
+	"dataResources": [
+		{
+			"resID": "learningData",
+			"resPath": "tables/learningData.csv",
+			"resType": "table",
+			"resFormat": {"text/csv": ["csv"]},
+			"isCollection": false,
+			"columns": [
+				{"colIndex": 0, "colName": "d3mIndex", "colType": "integer", "role": ["index"]},
+				{"colIndex": 1, "colName": "a", "colType": "string", "role": ["attribute"]},
+				{"colIndex": 2, "colName": "b", "colType": "string", "role": ["attribute"]}
+			]
+		}
+	]
+}`
+
+func TestWriteSubset(t *testing.T) {
+	folder, err := ioutil.TempDir("", "subset-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	source := path.Join(folder, "source")
+	sourceData := path.Join(source, "tables", "learningData.csv")
+	data := "d3mIndex,a,b\n0,x,y\n1,z,w\n"
+	if err := os.MkdirAll(path.Dir(sourceData), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sourceData, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sourceSchema := path.Join(source, "datasetDoc.json")
+	if err := ioutil.WriteFile(sourceSchema, []byte(testSubsetSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subset, err := WriteSubset(sourceSchema, NewFilter([]string{"b"}, nil), path.Join(folder, "subset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subset.ColumnCount != 3 || len(subset.Indices) != 2 || subset.Indices[1] != 2 {
+		t.Errorf("unexpected subset of %v from %d columns", subset.Indices, subset.ColumnCount)
+	}
+	written, err := ioutil.ReadFile(subset.DataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d3mIndex,b\n0,y\n1,w\n"; string(written) != expected {
+		t.Errorf("subset data %q, expected %q", string(written), expected)
+	}
+
+	unchanged, err := ioutil.ReadFile(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(unchanged) != data {
+		t.Errorf("source data was changed to %q", string(unchanged))
+	}
+	if schema, _ := ioutil.ReadFile(sourceSchema); string(schema) != testSubsetSchema {
+		t.Errorf("source schema was changed")
+	}
+}